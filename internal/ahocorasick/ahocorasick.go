@@ -0,0 +1,91 @@
+// Package ahocorasick finds occurrences of many literal patterns in a text
+// in a single pass, using the Aho–Corasick automaton.
+package ahocorasick
+
+type node struct {
+	next map[byte]int
+	fail int
+	// out lists the patterns ending at this node, including those reached
+	// through failure links.
+	out []int
+}
+
+// Automaton matches a fixed set of byte-string patterns. It is safe for
+// concurrent use once built.
+type Automaton struct {
+	nodes []node
+}
+
+// New builds an automaton over patterns. Patterns are identified by their
+// index in the slice; empty patterns are ignored.
+func New(patterns []string) *Automaton {
+	a := &Automaton{nodes: []node{{next: map[byte]int{}}}}
+
+	for i, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		state := 0
+		for j := 0; j < len(pattern); j++ {
+			next, ok := a.nodes[state].next[pattern[j]]
+			if !ok {
+				next = len(a.nodes)
+				a.nodes = append(a.nodes, node{next: map[byte]int{}})
+				a.nodes[state].next[pattern[j]] = next
+			}
+			state = next
+		}
+		a.nodes[state].out = append(a.nodes[state].out, i)
+	}
+
+	// Breadth-first, so each node's failure target is finished before its
+	// children need it.
+	queue := make([]int, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for b, child := range a.nodes[state].next {
+			fail := a.nodes[state].fail
+			for {
+				if target, ok := a.nodes[fail].next[b]; ok {
+					a.nodes[child].fail = target
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = a.nodes[fail].fail
+			}
+			target := a.nodes[child].fail
+			a.nodes[child].out = append(a.nodes[child].out, a.nodes[target].out...)
+			queue = append(queue, child)
+		}
+	}
+
+	return a
+}
+
+// Each calls fn with the index of every pattern occurring in text, once per
+// occurrence.
+func (a *Automaton) Each(text string, fn func(pattern int)) {
+	state := 0
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		for {
+			if next, ok := a.nodes[state].next[b]; ok {
+				state = next
+				break
+			}
+			if state == 0 {
+				break
+			}
+			state = a.nodes[state].fail
+		}
+		for _, pattern := range a.nodes[state].out {
+			fn(pattern)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package ahocorasick
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEach(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		text     string
+		// want counts the occurrences of each pattern by index.
+		want map[int]int
+	}{
+		{"overlapping", []string{"he", "she", "hers"}, "ushers", map[int]int{0: 1, 1: 1, 2: 1}},
+		{"pattern inside another", []string{"he", "she", "hers"}, "she said hers", map[int]int{0: 2, 1: 1, 2: 1}},
+		{"repeated occurrences", []string{"aa"}, "aaaa", map[int]int{0: 3}},
+		{"failure link to a suffix", []string{"abcd", "bcx"}, "abcx", map[int]int{1: 1}},
+		{"failure link after a repeated prefix", []string{"aab", "ab"}, "aaab", map[int]int{0: 1, 1: 1}},
+		{"failure link back to the root", []string{"abc", "xyz"}, "abxyz", map[int]int{1: 1}},
+		{"duplicates", []string{"ab", "ab"}, "xabx", map[int]int{0: 1, 1: 1}},
+		{"empty patterns are ignored", []string{"", "a", ""}, "aa", map[int]int{1: 2}},
+		{"at the end of the input", []string{"key", "ey"}, "my key", map[int]int{0: 1, 1: 1}},
+		{"cut off by the end of the input", []string{"keys"}, "my key", map[int]int{}},
+		{"whole input", []string{"sk_live_"}, "sk_live_", map[int]int{0: 1}},
+		{"empty input", []string{"a"}, "", map[int]int{}},
+		{"no patterns", nil, "text", map[int]int{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[int]int)
+			New(tt.patterns).Each(tt.text, func(pattern int) {
+				got[pattern]++
+			})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches of %q in %q = %v, want %v", tt.patterns, tt.text, got, tt.want)
+			}
+		})
+	}
+}
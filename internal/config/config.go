@@ -2,35 +2,428 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/harshinsecurity/mantramatch/internal/jsonpath"
 	"gopkg.in/yaml.v2"
 )
 
+// SuccessIndicator is a check on the verification response. all_of and any_of
+// combine Conditions, and not negates Condition, so checks can be nested.
 type SuccessIndicator struct {
-	Type  string `yaml:"type"`
-	Key   string `yaml:"key,omitempty"`
-	Value string `yaml:"value,omitempty"`
+	Type       string             `yaml:"type"`
+	Key        string             `yaml:"key,omitempty"`
+	Value      string             `yaml:"value,omitempty"`
+	Conditions []SuccessIndicator `yaml:"conditions,omitempty"`
+	Condition  *SuccessIndicator  `yaml:"condition,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Regexp returns the compiled Value of a regex_match indicator. LoadConfig
+// compiles it once; indicators built in code are compiled on each call.
+func (i SuccessIndicator) Regexp() (*regexp.Regexp, error) {
+	if i.regex != nil {
+		return i.regex, nil
+	}
+	return regexp.Compile(i.Value)
+}
+
+// HasType reports whether the indicator or any indicator nested in it is of
+// type t.
+func (i SuccessIndicator) HasType(t string) bool {
+	if i.Type == t {
+		return true
+	}
+	for _, condition := range i.Conditions {
+		if condition.HasType(t) {
+			return true
+		}
+	}
+	return i.Condition != nil && i.Condition.HasType(t)
 }
 
 type Validation struct {
-	StatusCode       int              `yaml:"status_code"`
+	StatusCode       int              `yaml:"status_code,omitempty"`
 	ContentType      string           `yaml:"content_type,omitempty"`
 	SuccessIndicator SuccessIndicator `yaml:"success_indicator"`
+	// InvalidIndicators match responses that definitively mean the key is
+	// revoked or bad. Responses that match neither these nor the success
+	// indicator are reported as unknown rather than invalid.
+	InvalidIndicators []SuccessIndicator `yaml:"invalid_indicators,omitempty"`
+}
+
+// Auth describes a built-in authentication scheme applied to the verification
+// request after the URL and headers have been rendered. Fields are templates
+// over the service's credential parts, just like verify_url and headers.
+type Auth struct {
+	Type string `yaml:"type"`
+
+	// basic
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+
+	// bearer and query; query also uses Param
+	Token string `yaml:"token,omitempty"`
+	Param string `yaml:"param,omitempty"`
+
+	// hmac; the signature goes in Header or, if unset, the Param query parameter
+	Secret          string `yaml:"secret,omitempty"`
+	Message         string `yaml:"message,omitempty"`
+	Algorithm       string `yaml:"algorithm,omitempty"`
+	Encoding        string `yaml:"encoding,omitempty"`
+	Header          string `yaml:"header,omitempty"`
+	TimestampHeader string `yaml:"timestamp_header,omitempty"`
+
+	// oauth2_client_credentials
+	TokenURL     string   `yaml:"token_url,omitempty"`
+	ClientID     string   `yaml:"client_id,omitempty"`
+	ClientSecret string   `yaml:"client_secret,omitempty"`
+	Scopes       []string `yaml:"scopes,omitempty"`
+	ClientAuth   string   `yaml:"client_auth,omitempty"`
+
+	// aws_sigv4
+	AccessKeyID     string `yaml:"access_key_id,omitempty"`
+	SecretAccessKey string `yaml:"secret_access_key,omitempty"`
+	SessionToken    string `yaml:"session_token,omitempty"`
+	Region          string `yaml:"region,omitempty"`
+	Service         string `yaml:"service,omitempty"`
+}
+
+// Extractor pulls a single value out of a step's response: a JSON path such
+// as "data.tenants.0.id", the first capture group of a regex over the body,
+// or a response header.
+type Extractor struct {
+	JSON   string `yaml:"json,omitempty"`
+	Regex  string `yaml:"regex,omitempty"`
+	Header string `yaml:"header,omitempty"`
+
+	regex *regexp.Regexp
+}
+
+// Regexp returns the compiled Regex. LoadConfig compiles it once; extractors
+// built in code are compiled on each call.
+func (e Extractor) Regexp() (*regexp.Regexp, error) {
+	if e.regex != nil {
+		return e.regex, nil
+	}
+	return regexp.Compile(e.Regex)
+}
+
+// Step is a request made before the verification request, for example to
+// exchange a secret for a token or look up a tenant ID. Values extracted from
+// its response are available to later steps and the verification request as
+// template fields.
+type Step struct {
+	Name     string               `yaml:"name,omitempty"`
+	URL      string               `yaml:"url"`
+	Method   string               `yaml:"method"`
+	Headers  map[string]string    `yaml:"headers,omitempty"`
+	Body     interface{}          `yaml:"body,omitempty"`
+	BodyType string               `yaml:"body_type,omitempty"`
+	Auth     *Auth                `yaml:"auth,omitempty"`
+	Extract  map[string]Extractor `yaml:"extract,omitempty"`
+}
+
+// Capability is a read-only request made after a key verifies, testing
+// whether the key grants one permission such as listing repositories. Headers
+// are added to the service's own, and the service's auth is used unless the
+// capability sets its own. The capability is granted when the response
+// passes its validation.
+type Capability struct {
+	Name        string            `yaml:"name"`
+	Description string            `yaml:"description,omitempty"`
+	Severity    string            `yaml:"severity"`
+	URL         string            `yaml:"url"`
+	Method      string            `yaml:"method"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	Body        interface{}       `yaml:"body,omitempty"`
+	BodyType    string            `yaml:"body_type,omitempty"`
+	Auth        *Auth             `yaml:"auth,omitempty"`
+	Validation  Validation        `yaml:"validation"`
+}
+
+// Severities lists the accepted severity ratings, from least to most severe.
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+// SeverityRank returns the position of severity in Severities, or -1 if it
+// isn't one of them.
+func SeverityRank(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Parameter is a value the user supplies alongside a key, such as a tenant
+// subdomain or region. It is available to templates under its name and can be
+// given per key in a list file, with -param, or through an environment variable.
+type Parameter struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Env         string `yaml:"env,omitempty"`
+	Default     string `yaml:"default,omitempty"`
+}
+
+// EnvVar returns the environment variable the parameter is read from.
+func (p Parameter) EnvVar() string {
+	if p.Env != "" {
+		return p.Env
+	}
+	return "MANTRAMATCH_" + strings.ToUpper(p.Name)
 }
 
 type Service struct {
-	Name         string            `yaml:"name"`
-	Regex        string            `yaml:"regex"`
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+	// Keywords are literals, one of which every key matching Regex
+	// contains (compared case-insensitively). Keys containing none of them
+	// skip the regex. When unset they are derived from Regex.
+	Keywords []string `yaml:"keywords,omitempty"`
+	// Context lists words that tend to appear near the service's keys, such
+	// as "twilio" in TWILIO_AUTH_TOKEN=. Found keys next to one of them are
+	// attributed to the service ahead of others matching the same pattern.
+	Context      []string          `yaml:"context,omitempty"`
+	Credentials  []string          `yaml:"credentials,omitempty"`
+	Parameters   []Parameter       `yaml:"parameters,omitempty"`
+	Steps        []Step            `yaml:"steps,omitempty"`
 	VerifyURL    string            `yaml:"verify_url"`
 	VerifyMethod string            `yaml:"verify_method"`
 	Headers      map[string]string `yaml:"headers,omitempty"`
+	Body         interface{}       `yaml:"body,omitempty"`
+	BodyType     string            `yaml:"body_type,omitempty"`
+	Auth         *Auth             `yaml:"auth,omitempty"`
 	Validation   Validation        `yaml:"validation"`
-	Note         string            `yaml:"note,omitempty"`
+	// Extract names fields to pull out of a successful verification
+	// response, such as the account or scopes the key belongs to.
+	Extract map[string]Extractor `yaml:"extract,omitempty"`
+	// Severity rates a valid key before any capabilities are probed.
+	Severity     string       `yaml:"severity,omitempty"`
+	Capabilities []Capability `yaml:"capabilities,omitempty"`
+	// Proxy overrides the global proxy for the service's requests, in the
+	// same form as HTTP.Proxy.
+	Proxy string `yaml:"proxy,omitempty"`
+	// RateLimit limits requests to each host the service sends them to, in
+	// the form accepted by ParseRate, e.g. "5/s".
+	RateLimit string `yaml:"rate_limit,omitempty"`
+	// Retry overrides the global retry settings for the service.
+	Retry *Retry `yaml:"retry,omitempty"`
+	Note  string `yaml:"note,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Compile compiles the service's regex. LoadConfig does this for every
+// service, so it is only needed for services built in code.
+func (s *Service) Compile() error {
+	pattern, err := regexp.Compile(s.Regex)
+	if err != nil {
+		return err
+	}
+	s.pattern = pattern
+	return nil
+}
+
+// Pattern returns the compiled regex, or nil if Compile hasn't been called.
+func (s Service) Pattern() *regexp.Regexp {
+	return s.pattern
+}
+
+// CredentialNames returns the names of the credential parts the service
+// expects, in the order they are supplied. The first part is the one matched
+// against Regex. Services that don't declare any take a single part, "key".
+func (s Service) CredentialNames() []string {
+	if len(s.Credentials) == 0 {
+		return []string{"key"}
+	}
+	names := make([]string, len(s.Credentials))
+	for i, name := range s.Credentials {
+		names[i] = strings.TrimSuffix(name, "?")
+	}
+	return names
+}
+
+// RequiredCredentials returns how many credential parts must be supplied.
+// Trailing parts whose names end in "?" are optional.
+func (s Service) RequiredCredentials() int {
+	required := len(s.CredentialNames())
+	for i := len(s.Credentials) - 1; i >= 0 && strings.HasSuffix(s.Credentials[i], "?"); i-- {
+		required--
+	}
+	return required
+}
+
+// VerifyStep returns the service's own verification request as the final
+// step of its flow.
+func (s Service) VerifyStep() Step {
+	return Step{
+		Name:     "verify",
+		URL:      s.VerifyURL,
+		Method:   s.VerifyMethod,
+		Headers:  s.Headers,
+		Body:     s.Body,
+		BodyType: s.BodyType,
+		Auth:     s.Auth,
+	}
+}
+
+// CapabilityStep returns the request that probes c for this service.
+func (s Service) CapabilityStep(c Capability) Step {
+	headers := make(map[string]string, len(s.Headers)+len(c.Headers))
+	for key, value := range s.Headers {
+		headers[key] = value
+	}
+	for key, value := range c.Headers {
+		headers[key] = value
+	}
+
+	auth := c.Auth
+	if auth == nil {
+		auth = s.Auth
+	}
+
+	return Step{
+		Name:     c.Name,
+		URL:      c.URL,
+		Method:   c.Method,
+		Headers:  headers,
+		Body:     c.Body,
+		BodyType: c.BodyType,
+		Auth:     auth,
+	}
+}
+
+// HTTP tunes the connection pool shared by all verification requests. Zero
+// values leave the built-in defaults in place.
+type HTTP struct {
+	MaxConnsPerHost     int  `yaml:"max_conns_per_host,omitempty"`
+	MaxIdleConnsPerHost int  `yaml:"max_idle_conns_per_host,omitempty"`
+	IdleConnTimeout     int  `yaml:"idle_conn_timeout,omitempty"` // seconds
+	TLSSessionCacheSize int  `yaml:"tls_session_cache_size,omitempty"`
+	DisableHTTP2        bool `yaml:"disable_http2,omitempty"`
+	DisableKeepAlives   bool `yaml:"disable_keep_alives,omitempty"`
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy for all
+	// verification traffic, or "direct" for none. When unset, the
+	// MANTRAMATCH_PROXY, HTTPS_PROXY and HTTP_PROXY environment variables
+	// are used.
+	Proxy string `yaml:"proxy,omitempty"`
+	// CABundle is a PEM file of extra certificates to trust, such as an
+	// intercepting proxy's CA.
+	CABundle string `yaml:"ca_bundle,omitempty"`
+	// RateLimit limits requests to every host, overriding the services'
+	// own rate limits.
+	RateLimit string `yaml:"rate_limit,omitempty"`
+	Retry     Retry  `yaml:"retry,omitempty"`
+}
+
+// Retry controls how requests that fail transiently, with a network error,
+// HTTP 429 or a 502, 503 or 504, are retried. Zero values leave the
+// defaults, or for a service the global settings, in place.
+type Retry struct {
+	// MaxAttempts counts the first attempt, so 1 turns retries off.
+	MaxAttempts int `yaml:"max_attempts,omitempty"`
+	// Backoff is the wait before the first retry, doubled for each one
+	// after, up to MaxBackoff. A Retry-After header replaces it, but
+	// retrying stops if it asks for a longer wait than MaxBackoff.
+	Backoff    time.Duration `yaml:"backoff,omitempty"`
+	MaxBackoff time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// Merge returns r with the fields set in override replacing its own.
+func (r Retry) Merge(override Retry) Retry {
+	if override.MaxAttempts != 0 {
+		r.MaxAttempts = override.MaxAttempts
+	}
+	if override.Backoff != 0 {
+		r.Backoff = override.Backoff
+	}
+	if override.MaxBackoff != 0 {
+		r.MaxBackoff = override.MaxBackoff
+	}
+	return r
+}
+
+// Rate is a number of requests allowed per period. The zero Rate allows any
+// number.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Unlimited reports whether r places no limit on requests.
+func (r Rate) Unlimited() bool {
+	return r.Requests == 0
+}
+
+// ParseRate parses a rate limit such as "5/s", "100/m", "1/h" or "10/30s".
+// "none" means no limit.
+func ParseRate(s string) (Rate, error) {
+	if s == "none" {
+		return Rate{}, nil
+	}
+	count, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("invalid rate limit %q: expected requests/period, e.g. 5/s", s)
+	}
+	requests, err := strconv.Atoi(count)
+	if err != nil || requests <= 0 {
+		return Rate{}, fmt.Errorf("invalid rate limit %q: requests must be a positive number", s)
+	}
+	var per time.Duration
+	switch period {
+	case "s":
+		per = time.Second
+	case "m":
+		per = time.Minute
+	case "h":
+		per = time.Hour
+	default:
+		per, err = time.ParseDuration(period)
+		if err != nil || per <= 0 {
+			return Rate{}, fmt.Errorf("invalid rate limit %q: period must be s, m, h or a duration such as 30s", s)
+		}
+	}
+	return Rate{Requests: requests, Per: per}, nil
+}
+
+// ProxySchemes lists the supported proxy URL schemes.
+var ProxySchemes = []string{"http", "https", "socks5"}
+
+// ParseProxy parses a proxy setting. It returns nil for "direct", meaning
+// requests shouldn't go through a proxy.
+func ParseProxy(raw string) (*url.URL, error) {
+	if raw == "direct" {
+		return nil, nil
+	}
+	proxy, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	supported := false
+	for _, scheme := range ProxySchemes {
+		if proxy.Scheme == scheme {
+			supported = true
+		}
+	}
+	if !supported {
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be one of %s", raw, strings.Join(ProxySchemes, ", "))
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", raw)
+	}
+	return proxy, nil
 }
 
 type Config struct {
+	HTTP     HTTP      `yaml:"http,omitempty"`
 	Services []Service `yaml:"services"`
 }
 
@@ -61,8 +454,9 @@ func CreateDefaultConfig(configPath string) error {
 				Regex:        "^[a-zA-Z0-9]{32}$",
 				VerifyURL:    "https://api.example.com/verify",
 				VerifyMethod: "GET",
-				Headers: map[string]string{
-					"Authorization": "Bearer %s",
+				Auth: &Auth{
+					Type:  "bearer",
+					Token: "{{.key}}",
 				},
 				Validation: Validation{
 					StatusCode: 200,
@@ -97,40 +491,377 @@ func validateConfig(config *Config) error {
 	if len(config.Services) == 0 {
 		return fmt.Errorf("no services defined in the configuration")
 	}
+	if err := validateHTTP(config.HTTP); err != nil {
+		return fmt.Errorf("invalid http settings: %w", err)
+	}
 
-	for _, service := range config.Services {
+	for i := range config.Services {
+		service := &config.Services[i]
 		if err := validateService(service); err != nil {
 			return fmt.Errorf("invalid service '%s': %w", service.Name, err)
 		}
+		if err := service.Compile(); err != nil {
+			return fmt.Errorf("invalid service '%s': invalid regex: %w", service.Name, err)
+		}
 	}
 
 	return nil
 }
 
-func validateService(service Service) error {
+func validateHTTP(settings HTTP) error {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"max_conns_per_host", settings.MaxConnsPerHost},
+		{"max_idle_conns_per_host", settings.MaxIdleConnsPerHost},
+		{"idle_conn_timeout", settings.IdleConnTimeout},
+		{"tls_session_cache_size", settings.TLSSessionCacheSize},
+	}
+	for _, limit := range limits {
+		if limit.value < 0 {
+			return fmt.Errorf("%s cannot be negative", limit.name)
+		}
+	}
+	if settings.Proxy != "" {
+		if _, err := ParseProxy(settings.Proxy); err != nil {
+			return err
+		}
+	}
+	if settings.RateLimit != "" {
+		if _, err := ParseRate(settings.RateLimit); err != nil {
+			return err
+		}
+	}
+	if err := validateRetry(settings.Retry); err != nil {
+		return fmt.Errorf("invalid retry: %w", err)
+	}
+	return nil
+}
+
+// validateService checks service and compiles the regexes in its
+// indicators and extractors.
+func validateService(service *Service) error {
 	if service.Name == "" {
 		return fmt.Errorf("service name cannot be empty")
 	}
 	if service.Regex == "" {
 		return fmt.Errorf("regex cannot be empty")
 	}
+	for _, keyword := range service.Keywords {
+		if keyword == "" {
+			return fmt.Errorf("keywords cannot be empty")
+		}
+	}
+	for _, keyword := range service.Context {
+		if keyword == "" {
+			return fmt.Errorf("context keywords cannot be empty")
+		}
+	}
 	if service.VerifyURL == "" {
 		return fmt.Errorf("verify URL cannot be empty")
 	}
 	if service.VerifyMethod == "" {
 		return fmt.Errorf("verify method cannot be empty")
 	}
-	if service.Validation.StatusCode == 0 {
+	if service.Validation.StatusCode == 0 && !service.Validation.SuccessIndicator.HasType("status_code") {
 		return fmt.Errorf("status code cannot be 0")
 	}
-	if err := validateSuccessIndicator(service.Validation.SuccessIndicator); err != nil {
+	if err := validateCredentials(service.Credentials); err != nil {
+		return fmt.Errorf("invalid credentials: %w", err)
+	}
+	if err := validateParameters(service.Parameters, service.CredentialNames()); err != nil {
+		return fmt.Errorf("invalid parameters: %w", err)
+	}
+	for i, step := range service.Steps {
+		if err := validateStep(step); err != nil {
+			return fmt.Errorf("invalid step %d: %w", i+1, err)
+		}
+	}
+	if err := validateBody(service.Body, service.BodyType); err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	if service.Auth != nil {
+		if err := validateAuth(*service.Auth); err != nil {
+			return fmt.Errorf("invalid auth: %w", err)
+		}
+	}
+	if err := validateSuccessIndicator(&service.Validation.SuccessIndicator); err != nil {
+		return fmt.Errorf("invalid success indicator: %w", err)
+	}
+	for i := range service.Validation.InvalidIndicators {
+		if err := validateSuccessIndicator(&service.Validation.InvalidIndicators[i]); err != nil {
+			return fmt.Errorf("invalid indicator %d: %w", i+1, err)
+		}
+	}
+	if err := validateExtractors(service.Extract); err != nil {
+		return fmt.Errorf("invalid extract: %w", err)
+	}
+	if service.Severity != "" && SeverityRank(service.Severity) < 0 {
+		return fmt.Errorf("invalid severity: %s", service.Severity)
+	}
+	seen := make(map[string]bool)
+	for i := range service.Capabilities {
+		capability := &service.Capabilities[i]
+		if err := validateCapability(capability); err != nil {
+			return fmt.Errorf("invalid capability %d: %w", i+1, err)
+		}
+		if seen[capability.Name] {
+			return fmt.Errorf("duplicate capability name: %s", capability.Name)
+		}
+		seen[capability.Name] = true
+	}
+	if service.Proxy != "" {
+		if _, err := ParseProxy(service.Proxy); err != nil {
+			return err
+		}
+	}
+	if service.RateLimit != "" {
+		if _, err := ParseRate(service.RateLimit); err != nil {
+			return err
+		}
+	}
+	if service.Retry != nil {
+		if err := validateRetry(*service.Retry); err != nil {
+			return fmt.Errorf("invalid retry: %w", err)
+		}
+	}
+	return validateTemplates(*service)
+}
+
+func validateRetry(retry Retry) error {
+	if retry.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts cannot be negative")
+	}
+	if retry.Backoff < 0 || retry.MaxBackoff < 0 {
+		return fmt.Errorf("backoff cannot be negative")
+	}
+	return nil
+}
+
+var credentialNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func validateCredentials(names []string) error {
+	seen := make(map[string]bool)
+	optional := false
+	for _, name := range names {
+		if strings.HasSuffix(name, "?") {
+			name = strings.TrimSuffix(name, "?")
+			optional = true
+		} else if optional {
+			return fmt.Errorf("required credential %s follows an optional one", name)
+		}
+		if !credentialNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid credential name: %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate credential name: %s", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
+
+func validateParameters(params []Parameter, credentialNames []string) error {
+	seen := make(map[string]bool)
+	for _, name := range credentialNames {
+		seen[name] = true
+	}
+	for _, param := range params {
+		if !credentialNameRegex.MatchString(param.Name) {
+			return fmt.Errorf("invalid parameter name: %q", param.Name)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("duplicate parameter name: %s", param.Name)
+		}
+		seen[param.Name] = true
+	}
+	return nil
+}
+
+func validateStep(step Step) error {
+	if step.URL == "" {
+		return fmt.Errorf("url cannot be empty")
+	}
+	if step.Method == "" {
+		return fmt.Errorf("method cannot be empty")
+	}
+	if err := validateBody(step.Body, step.BodyType); err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	if step.Auth != nil {
+		if err := validateAuth(*step.Auth); err != nil {
+			return fmt.Errorf("invalid auth: %w", err)
+		}
+	}
+	return validateExtractors(step.Extract)
+}
+
+func validateCapability(capability *Capability) error {
+	if capability.Name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if SeverityRank(capability.Severity) < 0 {
+		return fmt.Errorf("invalid severity: %q", capability.Severity)
+	}
+	if capability.URL == "" {
+		return fmt.Errorf("url cannot be empty")
+	}
+	if capability.Method == "" {
+		return fmt.Errorf("method cannot be empty")
+	}
+	if err := validateBody(capability.Body, capability.BodyType); err != nil {
+		return fmt.Errorf("invalid body: %w", err)
+	}
+	if capability.Auth != nil {
+		if err := validateAuth(*capability.Auth); err != nil {
+			return fmt.Errorf("invalid auth: %w", err)
+		}
+	}
+	if capability.Validation.StatusCode == 0 && !capability.Validation.SuccessIndicator.HasType("status_code") {
+		return fmt.Errorf("status code cannot be 0")
+	}
+	if err := validateSuccessIndicator(&capability.Validation.SuccessIndicator); err != nil {
 		return fmt.Errorf("invalid success indicator: %w", err)
 	}
 	return nil
 }
 
-func validateSuccessIndicator(indicator SuccessIndicator) error {
+func validateExtractors(extractors map[string]Extractor) error {
+	for name, extractor := range extractors {
+		if !credentialNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid extract name: %q", name)
+		}
+		if err := validateExtractor(&extractor); err != nil {
+			return fmt.Errorf("invalid extractor %s: %w", name, err)
+		}
+		extractors[name] = extractor
+	}
+	return nil
+}
+
+func validateExtractor(extractor *Extractor) error {
+	set := 0
+	for _, field := range []string{extractor.JSON, extractor.Regex, extractor.Header} {
+		if field != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of json, regex or header is required")
+	}
+	if extractor.Regex != "" {
+		regex, err := regexp.Compile(extractor.Regex)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		extractor.regex = regex
+	}
+	if extractor.JSON != "" {
+		if _, err := jsonpath.Compile(extractor.JSON); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateBody(body interface{}, bodyType string) error {
+	_, isString := body.(string)
+	_, isMap := body.(map[interface{}]interface{})
+
+	switch bodyType {
+	case "":
+	case "json":
+	case "form":
+		if body != nil && !isMap {
+			return fmt.Errorf("form body must be a mapping of field names to values")
+		}
+	case "raw":
+		if body != nil && !isString {
+			return fmt.Errorf("raw body must be a string")
+		}
+	default:
+		return fmt.Errorf("invalid body type: %s", bodyType)
+	}
+	return nil
+}
+
+func validateAuth(auth Auth) error {
+	switch auth.Type {
+	case "basic":
+		if auth.Username == "" {
+			return fmt.Errorf("username is required for type %s", auth.Type)
+		}
+	case "bearer":
+		if auth.Token == "" {
+			return fmt.Errorf("token is required for type %s", auth.Type)
+		}
+	case "query":
+		if auth.Param == "" || auth.Token == "" {
+			return fmt.Errorf("param and token are required for type %s", auth.Type)
+		}
+	case "hmac":
+		if auth.Secret == "" || auth.Message == "" {
+			return fmt.Errorf("secret and message are required for type %s", auth.Type)
+		}
+		if auth.Header == "" && auth.Param == "" {
+			return fmt.Errorf("header or param is required for type %s", auth.Type)
+		}
+		switch auth.Algorithm {
+		case "", "sha1", "sha256", "sha512":
+		default:
+			return fmt.Errorf("invalid hmac algorithm: %s", auth.Algorithm)
+		}
+		switch auth.Encoding {
+		case "", "hex", "base64":
+		default:
+			return fmt.Errorf("invalid hmac encoding: %s", auth.Encoding)
+		}
+	case "oauth2_client_credentials":
+		if auth.TokenURL == "" || auth.ClientID == "" || auth.ClientSecret == "" {
+			return fmt.Errorf("token_url, client_id and client_secret are required for type %s", auth.Type)
+		}
+		switch auth.ClientAuth {
+		case "", "basic", "body":
+		default:
+			return fmt.Errorf("invalid client_auth: %s", auth.ClientAuth)
+		}
+	case "aws_sigv4":
+		if auth.AccessKeyID == "" || auth.SecretAccessKey == "" {
+			return fmt.Errorf("access_key_id and secret_access_key are required for type %s", auth.Type)
+		}
+	default:
+		return fmt.Errorf("invalid auth type: %s", auth.Type)
+	}
+	return nil
+}
+
+// validateSuccessIndicator checks indicator and those nested in it, and
+// compiles regex_match values.
+func validateSuccessIndicator(indicator *SuccessIndicator) error {
+	switch indicator.Type {
+	case "all_of", "any_of":
+		if len(indicator.Conditions) == 0 {
+			return fmt.Errorf("conditions are required for type %s", indicator.Type)
+		}
+		for i := range indicator.Conditions {
+			if err := validateSuccessIndicator(&indicator.Conditions[i]); err != nil {
+				return fmt.Errorf("condition %d: %w", i+1, err)
+			}
+		}
+		return nil
+	case "not":
+		if indicator.Condition == nil {
+			return fmt.Errorf("condition is required for type not")
+		}
+		if err := validateSuccessIndicator(indicator.Condition); err != nil {
+			return fmt.Errorf("not: %w", err)
+		}
+		return nil
+	}
+
 	validTypes := map[string]bool{
+		"status_code":      true,
 		"status_code_only": true,
 		"json_key_exists":  true,
 		"json_key_value":   true,
@@ -151,12 +882,33 @@ func validateSuccessIndicator(indicator SuccessIndicator) error {
 		}
 	}
 
+	if indicator.Type == "json_key_exists" || indicator.Type == "json_key_value" {
+		if _, err := jsonpath.Compile(indicator.Key); err != nil {
+			return err
+		}
+	}
+
 	if indicator.Type == "json_key_value" || indicator.Type == "contains_string" ||
-		indicator.Type == "regex_match" || indicator.Type == "header_value" {
+		indicator.Type == "regex_match" || indicator.Type == "header_value" ||
+		indicator.Type == "status_code" {
 		if indicator.Value == "" {
 			return fmt.Errorf("value is required for type %s", indicator.Type)
 		}
 	}
 
+	if indicator.Type == "status_code" {
+		if _, err := strconv.Atoi(indicator.Value); err != nil {
+			return fmt.Errorf("invalid status code: %s", indicator.Value)
+		}
+	}
+
+	if indicator.Type == "regex_match" {
+		regex, err := regexp.Compile(indicator.Value)
+		if err != nil {
+			return fmt.Errorf("invalid regex: %w", err)
+		}
+		indicator.regex = regex
+	}
+
 	return nil
 }
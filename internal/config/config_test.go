@@ -0,0 +1,184 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadService loads a configuration holding one service whose definition
+// ends with extra, indented as a service field.
+func loadService(t *testing.T, extra string) (*Config, error) {
+	data := `services:
+  - name: Test
+    regex: '^[a-z]{8}$'
+    verify_url: https://example.com/
+    verify_method: GET
+` + extra
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigCompilesRegexes(t *testing.T) {
+	cfg, err := loadService(t, `    validation:
+      status_code: 200
+      success_indicator:
+        type: all_of
+        conditions:
+          - type: regex_match
+            value: '"id":\s*\d+'
+          - type: not
+            condition:
+              type: regex_match
+              value: 'suspended'
+      invalid_indicators:
+        - type: regex_match
+          value: 'invalid_(key|token)'
+    extract:
+      user:
+        regex: '"user":"([^"]+)"'
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := cfg.Services[0]
+	indicators := []SuccessIndicator{
+		service.Validation.SuccessIndicator.Conditions[0],
+		*service.Validation.SuccessIndicator.Conditions[1].Condition,
+		service.Validation.InvalidIndicators[0],
+	}
+	for _, indicator := range indicators {
+		if indicator.regex == nil {
+			t.Errorf("regex_match %q was not compiled", indicator.Value)
+		}
+	}
+	if service.Extract["user"].regex == nil {
+		t.Errorf("extractor regex was not compiled")
+	}
+}
+
+func TestLoadConfigRejectsBadRegexes(t *testing.T) {
+	tests := []struct {
+		name  string
+		extra string
+	}{
+		{"success indicator", `    validation:
+      status_code: 200
+      success_indicator:
+        type: regex_match
+        value: '([unclosed'
+`},
+		{"nested condition", `    validation:
+      status_code: 200
+      success_indicator:
+        type: any_of
+        conditions:
+          - type: contains_string
+            value: ok
+          - type: not
+            condition:
+              type: regex_match
+              value: '([unclosed'
+`},
+		{"invalid indicator", `    validation:
+      status_code: 200
+      success_indicator:
+        type: status_code_only
+      invalid_indicators:
+        - type: regex_match
+          value: '([unclosed'
+`},
+		{"extractor", `    validation:
+      status_code: 200
+      success_indicator:
+        type: status_code_only
+    extract:
+      user:
+        regex: '([unclosed'
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadService(t, tt.extra)
+			if err == nil || !strings.Contains(err.Error(), "invalid regex") {
+				t.Errorf("expected an invalid regex error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigChecksTemplates(t *testing.T) {
+	const validation = `    validation:
+      status_code: 200
+      success_indicator:
+        type: status_code_only
+`
+	tests := []struct {
+		name  string
+		extra string
+		err   string
+	}{
+		{"unclosed action", `    headers:
+      Authorization: "Bearer {{.key"
+`, "invalid template"},
+		{"undefined field", `    credentials: [account_sid, auth_token]
+    headers:
+      Authorization: "{{base64 (printf \"%s:%s\" .acount_sid .auth_token)}}"
+`, "undefined field acount_sid"},
+		{"undefined field in a nested body value", `    body:
+      user:
+        tokens: ["{{.tokn}}"]
+    body_type: json
+`, "undefined field tokn"},
+		{"undefined field in auth", `    auth:
+      type: bearer
+      token: "{{.secret}}"
+`, "undefined field secret"},
+		{"value extracted by a later step", `    steps:
+      - url: "https://example.com/{{.tenant}}"
+        method: GET
+      - url: https://example.com/tenant
+        method: GET
+        extract:
+          tenant:
+            json: id
+`, "undefined field tenant"},
+		{"parameters, extracted values and hmac fields", `    parameters:
+      - name: region
+    steps:
+      - url: "https://{{.region}}.example.com/token"
+        method: POST
+        extract:
+          token:
+            json: access_token
+    headers:
+      Authorization: "Bearer {{.token}}"
+    auth:
+      type: hmac
+      secret: "{{.key}}"
+      message: "{{.method}} {{.path}} {{.timestamp}}"
+      header: X-Signature
+`, ""},
+		{"legacy placeholder", `    headers:
+      Authorization: "Bearer %s"
+`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadService(t, tt.extra+validation)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("expected the templates to be valid, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateFuncs are the functions available to templates, besides the
+// text/template built-ins.
+var TemplateFuncs = template.FuncMap{
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"json": func(s string) (string, error) {
+		data, err := json.Marshal(s)
+		return string(data), err
+	},
+}
+
+// hmacFields are the values the hmac auth type adds for its secret and
+// message templates.
+var hmacFields = []string{"method", "path", "query", "body", "timestamp"}
+
+// templates caches parsed templates by their text.
+var templates sync.Map
+
+// ParseTemplate parses a verify_url, header, body or auth value. Text
+// without "{{" isn't a template but uses the legacy %s placeholder, and
+// yields a nil template.
+func ParseTemplate(text string) (*template.Template, error) {
+	if !strings.Contains(text, "{{") {
+		return nil, nil
+	}
+	if tmpl, ok := templates.Load(text); ok {
+		return tmpl.(*template.Template), nil
+	}
+	tmpl, err := template.New("").Funcs(TemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template %q: %w", text, err)
+	}
+	templates.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// validateTemplates checks the templates of every request the service
+// makes, in the order it makes them: each may refer to the credential
+// parts, the parameters and the values extracted by earlier steps.
+func validateTemplates(service Service) error {
+	fields := make(map[string]bool)
+	for _, name := range service.CredentialNames() {
+		fields[name] = true
+	}
+	for _, param := range service.Parameters {
+		fields[param.Name] = true
+	}
+	for i, step := range service.Steps {
+		if err := validateStepTemplates(step, fields); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+		for name := range step.Extract {
+			fields[name] = true
+		}
+	}
+	if err := validateStepTemplates(service.VerifyStep(), fields); err != nil {
+		return err
+	}
+	for _, capability := range service.Capabilities {
+		if err := validateStepTemplates(service.CapabilityStep(capability), fields); err != nil {
+			return fmt.Errorf("capability %s: %w", capability.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateStepTemplates(step Step, fields map[string]bool) error {
+	if err := validateTemplate(step.URL, fields); err != nil {
+		return fmt.Errorf("url: %w", err)
+	}
+	for name, value := range step.Headers {
+		if err := validateTemplate(value, fields); err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+	}
+	if err := validateBodyTemplates(step.Body, fields); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	if step.Auth != nil {
+		if err := validateAuthTemplates(*step.Auth, fields); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	return nil
+}
+
+func validateBodyTemplates(body interface{}, fields map[string]bool) error {
+	switch v := body.(type) {
+	case string:
+		return validateTemplate(v, fields)
+	case map[interface{}]interface{}:
+		for _, item := range v {
+			if err := validateBodyTemplates(item, fields); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := validateBodyTemplates(item, fields); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateAuthTemplates(auth Auth, fields map[string]bool) error {
+	for _, text := range []string{
+		auth.Username, auth.Password, auth.Token,
+		auth.TokenURL, auth.ClientID, auth.ClientSecret,
+		auth.AccessKeyID, auth.SecretAccessKey, auth.SessionToken,
+	} {
+		if err := validateTemplate(text, fields); err != nil {
+			return err
+		}
+	}
+
+	signing := make(map[string]bool, len(fields)+len(hmacFields))
+	for name := range fields {
+		signing[name] = true
+	}
+	for _, name := range hmacFields {
+		signing[name] = true
+	}
+	for _, text := range []string{auth.Secret, auth.Message} {
+		if err := validateTemplate(text, signing); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTemplate checks that text parses and refers only to the given
+// fields.
+func validateTemplate(text string, fields map[string]bool) error {
+	tmpl, err := ParseTemplate(text)
+	if err != nil || tmpl == nil {
+		return err
+	}
+	var unknown []string
+	walkFields(tmpl.Tree.Root, func(name string) {
+		if !fields[name] {
+			unknown = append(unknown, name)
+		}
+	})
+	if len(unknown) > 0 {
+		return fmt.Errorf("template %q refers to undefined field %s", text, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// walkFields calls fn with the name of every field of dot that node refers
+// to, such as "id" for {{.id}}. The bodies of range and with, where dot
+// changes, aren't visited.
+func walkFields(node parse.Node, fn func(string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			walkFields(child, fn)
+		}
+	case *parse.ActionNode:
+		walkFields(n.Pipe, fn)
+	case *parse.IfNode:
+		walkFields(n.Pipe, fn)
+		walkFields(n.List, fn)
+		walkFields(n.ElseList, fn)
+	case *parse.RangeNode:
+		walkFields(n.Pipe, fn)
+	case *parse.WithNode:
+		walkFields(n.Pipe, fn)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			walkFields(cmd, fn)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			walkFields(arg, fn)
+		}
+	case *parse.FieldNode:
+		fn(n.Ident[0])
+	case *parse.ChainNode:
+		walkFields(n.Node, fn)
+	}
+}
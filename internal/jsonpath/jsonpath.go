@@ -0,0 +1,214 @@
+// Package jsonpath evaluates simple JSONPath-style expressions against
+// values decoded by encoding/json.
+//
+// Supported syntax:
+//
+//	ok                  top-level key
+//	data.viewer.user    nested keys
+//	items[0].id         array index (items.0.id works too)
+//	items[-1]           index from the end
+//	items[*].name       every element of an array
+//	data.*              every value of an object
+//	["odd.key"]         quoted key, for keys containing dots or brackets
+//	$.data, $[0]        an optional leading $ refers to the document root
+package jsonpath
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type segmentKind int
+
+const (
+	keySegment segmentKind = iota
+	indexSegment
+	wildcardSegment
+)
+
+type segment struct {
+	kind  segmentKind
+	key   string
+	index int
+}
+
+// Path is a compiled path expression.
+type Path struct {
+	expr     string
+	segments []segment
+}
+
+// Compile parses expr into a Path.
+func Compile(expr string) (*Path, error) {
+	p := &Path{expr: expr}
+
+	rest := strings.TrimSpace(expr)
+	if rest == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	if strings.HasPrefix(rest, "$") {
+		rest = rest[1:]
+	}
+	rest = strings.TrimPrefix(rest, ".")
+
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := closingBracket(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated '[' in path %q", expr)
+			}
+			seg, err := parseBracket(rest[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid path %q: %w", expr, err)
+			}
+			p.segments = append(p.segments, seg)
+			rest = rest[end+1:]
+		case rest[0] == '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '.' {
+				return nil, fmt.Errorf("empty segment in path %q", expr)
+			}
+		default:
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			p.segments = append(p.segments, parseName(rest[:end]))
+			rest = rest[end:]
+		}
+	}
+
+	return p, nil
+}
+
+// MustCompile is like Compile but panics on error.
+func MustCompile(expr string) *Path {
+	p, err := Compile(expr)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// String returns the source expression.
+func (p *Path) String() string {
+	return p.expr
+}
+
+// Find returns every value the path selects in data, in document order
+// (object wildcards are visited in key order).
+func (p *Path) Find(data interface{}) []interface{} {
+	current := []interface{}{data}
+	for _, seg := range p.segments {
+		var next []interface{}
+		for _, node := range current {
+			next = append(next, seg.apply(node)...)
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// First returns the first value the path selects in data.
+func (p *Path) First(data interface{}) (interface{}, bool) {
+	matches := p.Find(data)
+	if len(matches) == 0 {
+		return nil, false
+	}
+	return matches[0], true
+}
+
+func (s segment) apply(node interface{}) []interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		switch s.kind {
+		case keySegment:
+			if value, ok := n[s.key]; ok {
+				return []interface{}{value}
+			}
+		case indexSegment:
+			// Numeric dotted segments may name object keys, e.g. "codes.200".
+			if s.key == "" {
+				return nil
+			}
+			if value, ok := n[s.key]; ok {
+				return []interface{}{value}
+			}
+		case wildcardSegment:
+			keys := make([]string, 0, len(n))
+			for key := range n {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			values := make([]interface{}, 0, len(keys))
+			for _, key := range keys {
+				values = append(values, n[key])
+			}
+			return values
+		}
+	case []interface{}:
+		switch s.kind {
+		case indexSegment:
+			index := s.index
+			if index < 0 {
+				index += len(n)
+			}
+			if index >= 0 && index < len(n) {
+				return []interface{}{n[index]}
+			}
+		case wildcardSegment:
+			return n
+		}
+	}
+	return nil
+}
+
+func parseName(name string) segment {
+	if name == "*" {
+		return segment{kind: wildcardSegment}
+	}
+	if index, err := strconv.Atoi(name); err == nil {
+		return segment{kind: indexSegment, index: index, key: name}
+	}
+	return segment{kind: keySegment, key: name}
+}
+
+func parseBracket(inner string) (segment, error) {
+	inner = strings.TrimSpace(inner)
+	if inner == "*" {
+		return segment{kind: wildcardSegment}, nil
+	}
+	if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+		return segment{kind: keySegment, key: inner[1 : len(inner)-1]}, nil
+	}
+	index, err := strconv.Atoi(inner)
+	if err != nil {
+		return segment{}, fmt.Errorf("bracket must hold an index, * or a quoted key, got %q", inner)
+	}
+	return segment{kind: indexSegment, index: index}, nil
+}
+
+// closingBracket returns the index of the ']' matching the '[' at s[0],
+// skipping over quoted keys.
+func closingBracket(s string) int {
+	var quote byte
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
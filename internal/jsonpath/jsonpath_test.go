@@ -0,0 +1,76 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"   ",
+		"a..b",
+		"a.",
+		"items[0",
+		"items[abc]",
+		"items[1.5]",
+		`["unterminated]`,
+	} {
+		if _, err := Compile(expr); err == nil {
+			t.Errorf("Compile(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	var doc interface{}
+	err := json.Unmarshal([]byte(`{
+		"ok": true,
+		"data": {"viewer": {"user": "octo"}},
+		"items": [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}],
+		"scores": {"b": 2, "a": 1},
+		"odd.key": "dotted",
+		"weird]key": "bracketed",
+		"codes": {"200": "OK"},
+		"empty": null
+	}`), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want []interface{}
+	}{
+		{"ok", []interface{}{true}},
+		{"$.ok", []interface{}{true}},
+		{`$["ok"]`, []interface{}{true}},
+		{"data.viewer.user", []interface{}{"octo"}},
+		{"items[0].id", []interface{}{1.0}},
+		{"items.1.id", []interface{}{2.0}},
+		{"items[-1].name", []interface{}{"b"}},
+		{"items[*].name", []interface{}{"a", "b"}},
+		{"items.*.id", []interface{}{1.0, 2.0}},
+		{"scores.*", []interface{}{1.0, 2.0}},
+		{`["odd.key"]`, []interface{}{"dotted"}},
+		{`['weird]key']`, []interface{}{"bracketed"}},
+		{"codes.200", []interface{}{"OK"}},
+		{"empty", []interface{}{nil}},
+		{"data.missing", nil},
+		{"items[2]", nil},
+		{"items[-3]", nil},
+		{"ok.x", nil},
+		{"codes[200]", nil},
+	}
+	for _, tt := range tests {
+		path, err := Compile(tt.expr)
+		if err != nil {
+			t.Errorf("Compile(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := path.Find(doc); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Find(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultMaxArchiveDepth   = 5
+	defaultMaxArchiveBytes   = 1 << 30
+	defaultMaxNestedZipBytes = 64 << 20
+)
+
+// archiveSeparator joins an archive's path to the path of an entry in it.
+const archiveSeparator = "!"
+
+// ErrArchiveTooLarge is reported for an archive whose decompressed content
+// exceeds MaxArchiveBytes. The rest of the archive isn't scanned.
+var ErrArchiveTooLarge = errors.New("archive exceeds the decompressed size limit")
+
+type contentKind int
+
+const (
+	textContent contentKind = iota
+	binaryContent
+	zipArchive
+	gzipArchive
+	tarArchive
+)
+
+// sniff classifies content by its first bytes.
+func sniff(head []byte) contentKind {
+	switch {
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")), bytes.HasPrefix(head, []byte("PK\x05\x06")):
+		return zipArchive
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return gzipArchive
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return tarArchive
+	case bytes.IndexByte(head, 0) >= 0:
+		return binaryContent
+	}
+	return textContent
+}
+
+// archiveState tracks the limits while scanning one file on disk and the
+// archives nested in it.
+type archiveState struct {
+	depth     int
+	remaining *int64
+	// layer is the container image layer being scanned, if any.
+	layer string
+}
+
+func (s *Scanner) newArchiveState() archiveState {
+	remaining := s.MaxArchiveBytes
+	return archiveState{remaining: &remaining}
+}
+
+// nested returns the state for the entries of an archive.
+func (st archiveState) nested() archiveState {
+	st.depth++
+	return st
+}
+
+// limitedReader counts the bytes a decompressor produces against the shared
+// budget. Only decompressed data is counted, and only once: tar entries and
+// stored zip entries are slices of data that was already counted, or of the
+// file on disk.
+type limitedReader struct {
+	r         io.Reader
+	remaining *int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if *l.remaining <= 0 {
+		// The budget is spent, but it's only exceeded if there is more.
+		var probe [1]byte
+		n, err := l.r.Read(probe[:])
+		if n > 0 {
+			return 0, ErrArchiveTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > *l.remaining {
+		p = p[:*l.remaining]
+	}
+	n, err := l.r.Read(p)
+	*l.remaining -= int64(n)
+	return n, err
+}
+
+// scanEntry scans content that may be text or an archive.
+func (s *Scanner) scanEntry(path string, reader *bufio.Reader, st archiveState, fn func(Finding)) error {
+	head, _ := reader.Peek(sniffLen)
+	kind := sniff(head)
+
+	switch kind {
+	case textContent:
+		if st.layer != "" {
+			layer := st.layer
+			return s.scanText(path, reader, func(f Finding) {
+				f.Layer = layer
+				fn(f)
+			})
+		}
+		return s.scanText(path, reader, fn)
+	case binaryContent:
+		return nil
+	}
+
+	if st.depth >= s.MaxArchiveDepth {
+		return fmt.Errorf("archive nested more than %d deep", s.MaxArchiveDepth)
+	}
+
+	switch kind {
+	case gzipArchive:
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		inner := bufio.NewReader(&limitedReader{r: gz, remaining: st.remaining})
+		return s.scanEntry(path, inner, st.nested(), fn)
+	case tarArchive:
+		return s.scanTar(path, reader, st.nested(), fn)
+	case zipArchive:
+		// Nested zips have to be read into memory for random access.
+		data, err := io.ReadAll(io.LimitReader(reader, s.MaxNestedZipBytes+1))
+		if err != nil {
+			return err
+		}
+		if int64(len(data)) > s.MaxNestedZipBytes {
+			return fmt.Errorf("nested zip larger than %d bytes", s.MaxNestedZipBytes)
+		}
+		return s.scanZip(path, bytes.NewReader(data), int64(len(data)), st, fn)
+	}
+	return nil
+}
+
+func (s *Scanner) scanTar(path string, r io.Reader, st archiveState, fn func(Finding)) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path + archiveSeparator + header.Name
+		reader := bufio.NewReader(tr)
+
+		entry := st
+		if layer := imageLayer(header.Name); layer != "" {
+			// Image config and manifest blobs share the layers' naming, so
+			// only archives count as layers.
+			head, _ := reader.Peek(sniffLen)
+			if kind := sniff(head); kind == tarArchive || kind == gzipArchive {
+				entry.layer = layer
+			}
+		}
+		if err := s.scanEntry(name, reader, entry, fn); err != nil {
+			if errors.Is(err, ErrArchiveTooLarge) {
+				return err
+			}
+			s.reportError(name, err)
+		}
+	}
+}
+
+func (s *Scanner) scanZip(path string, r io.ReaderAt, size int64, st archiveState, fn func(Finding)) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+	st = st.nested()
+	for _, file := range zr.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+		name := path + archiveSeparator + file.Name
+		if err := s.scanZipFile(name, file, st, fn); err != nil {
+			if errors.Is(err, ErrArchiveTooLarge) {
+				return err
+			}
+			s.reportError(name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Scanner) scanZipFile(name string, file *zip.File, st archiveState, fn func(Finding)) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	var r io.Reader = rc
+	if file.Method != zip.Store {
+		r = &limitedReader{r: rc, remaining: st.remaining}
+	}
+	return s.scanEntry(name, bufio.NewReader(r), st, fn)
+}
+
+var (
+	// ociBlob matches layer blobs in an OCI image layout, as written by
+	// docker save since Docker 25.
+	ociBlob = regexp.MustCompile(`(?:^|/)blobs/sha256/([0-9a-f]{64})$`)
+	// dockerLayer matches layers in the legacy docker save format.
+	dockerLayer = regexp.MustCompile(`(?:^|/)([0-9a-f]{64})/layer\.tar$`)
+)
+
+// imageLayer returns the layer digest if name is a layer of a container
+// image tarball.
+func imageLayer(name string) string {
+	name = strings.TrimPrefix(name, "./")
+	if m := ociBlob.FindStringSubmatch(name); m != nil {
+		return "sha256:" + m[1]
+	}
+	if m := dockerLayer.FindStringSubmatch(name); m != nil {
+		return m[1]
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+package scan
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipped stores a single file uncompressed, so the zip is as large as its
+// content.
+func zipped(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func tarred(t *testing.T, name string, data []byte) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scanArchive scans data and returns the findings, the errors reported for
+// entries, and the error ScanReader returned.
+func scanArchive(s *Scanner, path string, data []byte) (findings []Finding, reported []error, err error) {
+	s.OnError = func(path string, err error) {
+		reported = append(reported, err)
+	}
+	err = s.ScanReader(path, bytes.NewReader(data), func(f Finding) {
+		findings = append(findings, f)
+	})
+	return findings, reported, err
+}
+
+func TestArchiveDepthLimit(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	data := []byte("STRIPE_KEY=" + stripeKey + "\n")
+	for i := 0; i < defaultMaxArchiveDepth; i++ {
+		data = gzipped(t, data)
+	}
+
+	findings, _, err := scanArchive(s, "key.gz", data)
+	if err != nil || len(findings) != 1 || findings[0].Path != "key.gz" {
+		t.Fatalf("expected the key %d archives deep, got %+v, %v", defaultMaxArchiveDepth, findings, err)
+	}
+
+	findings, _, err = scanArchive(s, "key.gz", gzipped(t, data))
+	if err == nil || !strings.Contains(err.Error(), "nested more than") || len(findings) != 0 {
+		t.Errorf("expected the depth limit to stop the scan, got %+v, %v", findings, err)
+	}
+}
+
+func TestArchiveSizeLimit(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	s.MaxArchiveBytes = 1 << 10
+	data := []byte(strings.Repeat("padding\n", 512) + "STRIPE_KEY=" + stripeKey + "\n")
+
+	findings, _, err := scanArchive(s, "big.gz", gzipped(t, data))
+	if !errors.Is(err, ErrArchiveTooLarge) || len(findings) != 0 {
+		t.Errorf("expected the size limit to stop the scan, got %+v, %v", findings, err)
+	}
+
+	s.MaxArchiveBytes = 1 << 20
+	findings, _, err = scanArchive(s, "big.gz", gzipped(t, data))
+	if err != nil || len(findings) != 1 {
+		t.Errorf("expected the key within a larger limit, got %+v, %v", findings, err)
+	}
+}
+
+func TestArchiveSizeLimitCountsDecompressedBytesOnce(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	// A gzipped layer inside a gzipped image: only the two gzip streams'
+	// output counts, not the tar entries read from them.
+	inner := tarred(t, "app/.env", []byte(strings.Repeat("padding\n", 512)+"STRIPE_KEY="+stripeKey+"\n"))
+	outer := tarred(t, "layer.tar.gz", gzipped(t, inner))
+	data := gzipped(t, outer)
+	decompressed := int64(len(outer) + len(inner))
+
+	s.MaxArchiveBytes = decompressed
+	findings, reported, err := scanArchive(s, "image.tar.gz", data)
+	if err != nil || len(reported) != 0 || len(findings) != 1 || findings[0].Path != "image.tar.gz!layer.tar.gz!app/.env" {
+		t.Fatalf("expected the key exactly at the limit, got %+v, %v, %v", findings, reported, err)
+	}
+
+	s.MaxArchiveBytes = decompressed - 1
+	if _, _, err := scanArchive(s, "image.tar.gz", data); !errors.Is(err, ErrArchiveTooLarge) {
+		t.Errorf("expected the size limit one byte short, got %v", err)
+	}
+}
+
+func TestNestedZipLimit(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	inner := zipped(t, "key.env", []byte(strings.Repeat("padding\n", 512)+"STRIPE_KEY="+stripeKey+"\n"))
+	data := tarred(t, "inner.zip", inner)
+
+	findings, reported, err := scanArchive(s, "outer.tar", data)
+	if err != nil || len(reported) != 0 || len(findings) != 1 || findings[0].Path != "outer.tar!inner.zip!key.env" {
+		t.Fatalf("expected the key in the nested zip, got %+v, %v, %v", findings, reported, err)
+	}
+
+	s.MaxNestedZipBytes = 1 << 10
+	findings, reported, err = scanArchive(s, "outer.tar", data)
+	if err != nil || len(findings) != 0 || len(reported) != 1 || !strings.Contains(reported[0].Error(), "nested zip larger") {
+		t.Errorf("expected the nested zip to be skipped with an error, got %+v, %v, %v", findings, reported, err)
+	}
+}
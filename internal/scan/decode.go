@@ -0,0 +1,176 @@
+package scan
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/harshinsecurity/mantramatch/internal/service"
+)
+
+const defaultMaxDecodeDepth = 2
+
+// Decoder reveals secrets hidden under an encoding, such as the base64
+// values of a Kubernetes Secret manifest.
+type Decoder struct {
+	Name string
+	// token matches the encoded strings in a line.
+	token *regexp.Regexp
+	// decode returns the decoded text, or false if s doesn't decode to
+	// text.
+	decode func(s string) (string, bool)
+}
+
+// Decoders are the available decoders, in the order they are tried.
+var Decoders = []Decoder{
+	{
+		Name:   "base64",
+		token:  regexp.MustCompile(`[A-Za-z0-9+/_-]{20,}={0,2}`),
+		decode: decodeBase64,
+	},
+	{
+		// Each query parameter name and value is a separate token, so that
+		// one escaped parameter doesn't make a whole URL count as encoded.
+		// '+' is left as it is: keys contain it far more often than
+		// form-encoded spaces do.
+		Name:  "url",
+		token: regexp.MustCompile(`[^\s"'<>?&=#]*%[0-9A-Fa-f]{2}[^\s"'<>?&=#]*`),
+		decode: func(s string) (string, bool) {
+			decoded, err := url.PathUnescape(s)
+			if err != nil {
+				return "", false
+			}
+			return decoded, isText(decoded)
+		},
+	},
+	{
+		Name:  "hex",
+		token: regexp.MustCompile(`\b(?:[0-9A-Fa-f]{2}){10,}\b`),
+		decode: func(s string) (string, bool) {
+			decoded, err := hex.DecodeString(s)
+			if err != nil {
+				return "", false
+			}
+			return string(decoded), isText(string(decoded))
+		},
+	},
+}
+
+// LookupDecoder returns the decoder with the given name.
+func LookupDecoder(name string) (Decoder, bool) {
+	for _, d := range Decoders {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return Decoder{}, false
+}
+
+// decodeBase64 accepts standard and URL-safe base64, padded or not.
+func decodeBase64(s string) (string, bool) {
+	for _, encoding := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if decoded, err := encoding.DecodeString(s); err == nil {
+			return string(decoded), isText(string(decoded))
+		}
+	}
+	return "", false
+}
+
+// isText reports whether decoded data looks like text rather than the
+// random bytes most strings happen to decode to.
+func isText(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if !unicode.IsPrint(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// decodedSpan is an encoded string that decoded to text. unchanged holds the
+// secrets matched inside it that decoding left as they were, such as a key
+// next to an escaped character; those are reported as plain matches rather
+// than as decoded.
+type decodedSpan struct {
+	start, end int
+	unchanged  map[string]bool
+}
+
+// hidden reports whether one of spans stands in for a plain match of secret
+// at hit, because decoding changed it.
+func hidden(hit service.Hit, secret string, spans []decodedSpan) bool {
+	for _, span := range spans {
+		if hit.Start >= span.start && hit.End <= span.end && !span.unchanged[secret] {
+			return true
+		}
+	}
+	return false
+}
+
+// findSecrets reports the secrets in text, both as they are and hidden
+// under further encodings until chain, the decoders already applied,
+// reaches MaxDecodeDepth. A match inside an encoded string that decodes to
+// text is only reported as it is if decoding doesn't change it. locate
+// returns the finding for a position in text.
+func (s *Scanner) findSecrets(text string, chain []string, locate func(start, end int) Finding, fn func(Finding)) {
+	hits := s.matcher.Find(text)
+	var spans []decodedSpan
+	if len(chain) < s.MaxDecodeDepth {
+		for _, d := range s.Decoders {
+			for _, loc := range d.token.FindAllStringIndex(text, -1) {
+				decoded, ok := d.decode(text[loc[0]:loc[1]])
+				if !ok {
+					continue
+				}
+				span := decodedSpan{start: loc[0], end: loc[1], unchanged: make(map[string]bool)}
+				for _, hit := range hits {
+					secret := text[hit.Start:hit.End]
+					if hit.Start >= span.start && hit.End <= span.end && strings.Contains(decoded, secret) {
+						span.unchanged[secret] = true
+					}
+				}
+				spans = append(spans, span)
+				chain := append(chain[:len(chain):len(chain)], d.Name)
+				outer := locate(loc[0], loc[1])
+				report := func(f Finding) {
+					if !span.unchanged[f.Secret] {
+						fn(f)
+					}
+				}
+				for _, line := range strings.Split(decoded, "\n") {
+					line = strings.TrimRight(line, "\r")
+					s.findSecrets(line, chain, func(start, end int) Finding {
+						// Positions refer to the outermost encoded string,
+						// but the decoded text may name the secret.
+						finding := outer
+						if m := assignment.FindStringSubmatch(line[:start]); m != nil {
+							finding.KeyName = m[1]
+						}
+						finding.Nearby += "\n" + line
+						return finding
+					}, report)
+				}
+			}
+		}
+	}
+
+	for _, hit := range hits {
+		secret := text[hit.Start:hit.End]
+		if hidden(hit, secret, spans) {
+			continue
+		}
+		finding := locate(hit.Start, hit.End)
+		finding.Secret = secret
+		finding.Decoded = chain
+		fn(finding)
+	}
+}
@@ -0,0 +1,61 @@
+package scan
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindEncodedSecrets(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`, `^pk_[A-Za-z0-9+/]{20}$`)
+	s.Decoders = Decoders
+
+	const publishable = "pk_abcd+efgh/ijkl+mnop1"
+	b64 := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		name    string
+		line    string
+		secret  string
+		column  int
+		keyName string
+		decoded []string
+	}{
+		{"base64", "data: " + b64([]byte("STRIPE_KEY="+stripeKey)), stripeKey, 7, "STRIPE_KEY", []string{"base64"}},
+		{"hex in base64", "v=" + b64([]byte(hex.EncodeToString([]byte(stripeKey)))), stripeKey, 3, "v", []string{"base64", "hex"}},
+		{"url-encoded parameter", "https://h/?a=b&token=sk_live%5Fabcdefghijklmnopqrstuvwx", stripeKey, 22, "token", []string{"url"}},
+		{"plain key beside an escaped parameter", "url: https://x.com/?a=%20&k=" + stripeKey, stripeKey, 29, "k", nil},
+		{"plus signs aren't spaces", `curl "https://h/?q=a%20b&token=` + publishable + `"`, publishable, 32, "token", nil},
+		{"key after an escaped space", "q=a%20" + publishable, publishable, 3, "q", []string{"url"}},
+		{"plain key inside an escaped path", "see https://h/%7Euser/" + stripeKey, stripeKey, 23, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := scanLines(s, tt.line)
+			if len(findings) != 1 {
+				t.Fatalf("expected 1 finding, got %+v", findings)
+			}
+			f := findings[0]
+			if f.Secret != tt.secret || f.Column != tt.column || f.KeyName != tt.keyName || !reflect.DeepEqual(f.Decoded, tt.decoded) {
+				t.Errorf("got %q at column %d named %q decoded %v, want %q at column %d named %q decoded %v",
+					f.Secret, f.Column, f.KeyName, f.Decoded, tt.secret, tt.column, tt.keyName, tt.decoded)
+			}
+		})
+	}
+}
+
+func TestDecodeDepth(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	s.Decoders = Decoders
+	line := base64.StdEncoding.EncodeToString([]byte(stripeKey))
+	line = base64.StdEncoding.EncodeToString([]byte("x " + line))
+
+	if findings := scanLines(s, line); len(findings) != 1 || strings.Join(findings[0].Decoded, ",") != "base64,base64" {
+		t.Errorf("expected the key under two encodings, got %+v", findings)
+	}
+	s.MaxDecodeDepth = 1
+	if findings := scanLines(s, line); len(findings) != 0 {
+		t.Errorf("expected nothing beyond -decode-depth 1, got %+v", findings)
+	}
+}
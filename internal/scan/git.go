@@ -0,0 +1,159 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// commitMarker starts each commit header in the log output; diff lines
+// can't begin with a NUL byte.
+const commitMarker = "\x00commit "
+
+// Commit identifies the commit a finding in git history was added in.
+type Commit struct {
+	Hash   string
+	Author string
+	Email  string
+	Date   time.Time
+}
+
+// ScanGit scans the history of the git repository at repo: every commit
+// reachable from any branch, tag or remote, and every stash entry. Only
+// added lines are matched, and each finding carries the commit that added
+// it. The git command must be installed.
+func (s *Scanner) ScanGit(repo string, fn func(Finding)) error {
+	stashes, err := gitOutput(repo, "stash", "list", "--format=%H")
+	if err != nil {
+		return err
+	}
+
+	args := []string{
+		"-C", repo, "log", "--all", "--patch", "-m", "--unified=0",
+		"--no-color", "--no-ext-diff", "--no-renames",
+		"--format=%x00commit %H%x00%an%x00%ae%x00%aI",
+	}
+	args = append(args, strings.Fields(stashes)...)
+
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running git: %w", err)
+	}
+
+	parseErr := s.scanLog(stdout, fn)
+	// Drain the pipe so git can exit if parsing stopped early.
+	io.Copy(io.Discard, stdout)
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git log failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return parseErr
+}
+
+// scanLog reads the output of git log --patch, matching added lines.
+func (s *Scanner) scanLog(r io.Reader, fn func(Finding)) error {
+	reader := bufio.NewReader(r)
+
+	var commit *Commit
+	var path, prev string
+	lineNo := 0
+	inHunk := false
+
+	for {
+		line, err := reader.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case strings.HasPrefix(line, commitMarker):
+			commit = parseCommitHeader(line[len(commitMarker):])
+			path, inHunk = "", false
+		case strings.HasPrefix(line, "diff --git "):
+			path, inHunk = "", false
+		case !inHunk && strings.HasPrefix(line, "+++ "):
+			path = diffPath(line[len("+++ "):])
+		case strings.HasPrefix(line, "@@ "):
+			lineNo, inHunk, prev = hunkStart(line), true, ""
+		case inHunk && strings.HasPrefix(line, "+"):
+			if path != "" && commit != nil {
+				c := commit
+				s.scanLine(path, lineNo, line[1:], prev, func(f Finding) {
+					f.Commit = c
+					fn(f)
+				})
+			}
+			lineNo++
+			prev = line[1:]
+		case inHunk && strings.HasPrefix(line, " "):
+			lineNo++
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func parseCommitHeader(header string) *Commit {
+	fields := strings.Split(header, "\x00")
+	commit := &Commit{Hash: fields[0]}
+	if len(fields) >= 4 {
+		commit.Author = fields[1]
+		commit.Email = fields[2]
+		commit.Date, _ = time.Parse(time.RFC3339, fields[3])
+	}
+	return commit
+}
+
+// diffPath returns the path from a "+++ b/path" line, or "" for deleted
+// files.
+func diffPath(name string) string {
+	name = strings.TrimSuffix(name, "\t")
+	if strings.HasPrefix(name, `"`) {
+		if unquoted, err := strconv.Unquote(name); err == nil {
+			name = unquoted
+		}
+	}
+	if name == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(name, "b/")
+}
+
+// hunkStart returns the first new-file line number from a hunk header such
+// as "@@ -1,2 +3,4 @@".
+func hunkStart(header string) int {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 1
+	}
+	start, _, _ := strings.Cut(fields[2][1:], ",")
+	n, err := strconv.Atoi(start)
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+func gitOutput(repo string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScanLog(t *testing.T) {
+	s := newTestScanner(t, `^sk_live_[0-9a-zA-Z]{24}$`)
+	key := func(c string) string { return "sk_live_" + strings.Repeat(c, 24) }
+
+	log := strings.Join([]string{
+		commitMarker + "aaaa\x00Alice\x00alice@example.com\x002024-01-02T03:04:05Z",
+		"",
+		"diff --git a/config.env b/config.env",
+		"index 0000000..1111111 100644",
+		"--- a/config.env",
+		"+++ b/config.env",
+		"@@ -1,0 +2,3 @@",
+		"+# stripe",
+		"+STRIPE_KEY=" + key("a"),
+		"+++ not a header " + key("b"),
+		"@@ -10 +12,0 @@",
+		"-removed " + key("x"),
+		"@@ -20,2 +30,3 @@",
+		" unchanged",
+		"-replaced",
+		"+other=" + key("c"),
+		`diff --git "a/caf\303\251.txt" "b/caf\303\251.txt"`,
+		"new file mode 100644",
+		"--- /dev/null",
+		`+++ "b/caf\303\251.txt"`,
+		"@@ -0,0 +1 @@",
+		"+" + key("d"),
+		"\\ No newline at end of file",
+		"diff --git a/gone.txt b/gone.txt",
+		"deleted file mode 100644",
+		"--- a/gone.txt",
+		"+++ /dev/null",
+		"@@ -1 +0,0 @@",
+		"-" + key("y"),
+		// git log -m shows a merge once per parent.
+		commitMarker + "mmmm\x00Bob\x00bob@example.com\x002024-02-01T00:00:00Z",
+		"",
+		"diff --git a/m.txt b/m.txt",
+		"--- a/m.txt",
+		"+++ b/m.txt",
+		"@@ -1 +1 @@",
+		"-x",
+		"+" + key("e"),
+		commitMarker + "mmmm\x00Bob\x00bob@example.com\x002024-02-01T00:00:00Z",
+		"",
+		"diff --git a/m.txt b/m.txt",
+		"--- a/m.txt",
+		"+++ b/m.txt",
+		"@@ -4,0 +5 @@",
+		"+" + key("e"),
+		"",
+	}, "\n")
+
+	type location struct {
+		secret, path string
+		line         int
+		commit       string
+	}
+	want := []location{
+		{key("a"), "config.env", 3, "aaaa"},
+		{key("b"), "config.env", 4, "aaaa"},
+		{key("c"), "config.env", 31, "aaaa"},
+		{key("d"), "café.txt", 1, "aaaa"},
+		{key("e"), "m.txt", 1, "mmmm"},
+		{key("e"), "m.txt", 5, "mmmm"},
+	}
+
+	var findings []Finding
+	if err := s.scanLog(strings.NewReader(log), func(f Finding) { findings = append(findings, f) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != len(want) {
+		t.Fatalf("expected %d findings, got %+v", len(want), findings)
+	}
+	for i, f := range findings {
+		got := location{f.Secret, f.Path, f.Line, f.Commit.Hash}
+		if got != want[i] {
+			t.Errorf("finding %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+	if findings[0].KeyName != "STRIPE_KEY" {
+		t.Errorf("KeyName = %q, want STRIPE_KEY", findings[0].KeyName)
+	}
+	if !strings.HasPrefix(findings[1].Nearby, "STRIPE_KEY=") {
+		t.Errorf("expected the previous added line as context, got %q", findings[1].Nearby)
+	}
+	commit := findings[0].Commit
+	if commit.Author != "Alice" || commit.Email != "alice@example.com" || !commit.Date.Equal(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("unexpected commit %+v", commit)
+	}
+}
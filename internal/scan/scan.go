@@ -0,0 +1,209 @@
+// Package scan finds possible secrets embedded in files and directories,
+// including inside archives and container image tarballs, and in git
+// history. Secrets hidden under encodings such as base64 are decoded.
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/harshinsecurity/mantramatch/internal/service"
+)
+
+// sniffLen is how much of a file is checked for NUL bytes to decide whether
+// it is binary.
+const sniffLen = 8000
+
+// skipDirs are directories never descended into.
+var skipDirs = map[string]bool{
+	".git": true,
+	".hg":  true,
+	".svn": true,
+}
+
+// Finding is a possible secret found in a file. Line and Column are
+// 1-based; Column counts characters, not bytes.
+type Finding struct {
+	Path   string
+	Line   int
+	Column int
+	Secret string
+	// KeyName is the name the secret is assigned to on its line, such as
+	// TWILIO_AUTH_TOKEN in an .env file or "sendgrid_key" in JSON. Nearby
+	// is the rest of the line, plus the line before it when that opens the
+	// enclosing block or the secret isn't assigned to a name.
+	KeyName string
+	Nearby  string
+	// Commit is the commit that added the line, for findings in git
+	// history.
+	Commit *Commit
+	// Layer identifies the image layer the file came from, for findings in
+	// container image tarballs.
+	Layer string
+	// Decoded lists the decoders, outermost first, that revealed a secret
+	// found encoded. Path, Line and Column locate the encoded string.
+	Decoded []string
+}
+
+// Location returns the finding's position as path:line:column.
+func (f Finding) Location() string {
+	return fmt.Sprintf("%s:%d:%d", f.Path, f.Line, f.Column)
+}
+
+// Scanner looks for text matching any service's regex.
+type Scanner struct {
+	matcher *service.Matcher
+	// OnError is called for files that can't be read. The walk continues
+	// either way.
+	OnError func(path string, err error)
+	// MaxArchiveDepth limits how deeply archives nested in archives are
+	// opened, and MaxArchiveBytes how much data is decompressed from each
+	// file on disk, guarding against zip bombs. Zips nested in other
+	// archives are held in memory, so they are limited to
+	// MaxNestedZipBytes each.
+	MaxArchiveDepth   int
+	MaxArchiveBytes   int64
+	MaxNestedZipBytes int64
+	// Decoders are tried on each line to find encoded secrets, nesting up
+	// to MaxDecodeDepth encodings.
+	Decoders       []Decoder
+	MaxDecodeDepth int
+}
+
+// New returns a Scanner that finds the keys matcher recognises.
+func New(matcher *service.Matcher) *Scanner {
+	return &Scanner{
+		matcher:           matcher,
+		MaxArchiveDepth:   defaultMaxArchiveDepth,
+		MaxArchiveBytes:   defaultMaxArchiveBytes,
+		MaxNestedZipBytes: defaultMaxNestedZipBytes,
+		Decoders:          Decoders,
+		MaxDecodeDepth:    defaultMaxDecodeDepth,
+	}
+}
+
+// ScanPath scans root, which may be a file or a directory, calling fn for
+// each finding in walk order. Binary files are skipped.
+func (s *Scanner) ScanPath(root string, fn func(Finding)) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			s.reportError(path, err)
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && skipDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := s.scanFile(path, fn); err != nil {
+			s.reportError(path, err)
+		}
+		return nil
+	})
+}
+
+func (s *Scanner) scanFile(path string, fn func(Finding)) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	head, _ := reader.Peek(sniffLen)
+	if sniff(head) == zipArchive {
+		// Zip needs random access, which the file already provides.
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		return s.scanZip(path, file, info.Size(), s.newArchiveState(), fn)
+	}
+	return s.scanEntry(path, reader, s.newArchiveState(), fn)
+}
+
+// ScanReader scans text read from r, reporting findings under the given
+// path. Archives are scanned recursively and other binary input is skipped.
+func (s *Scanner) ScanReader(path string, r io.Reader, fn func(Finding)) error {
+	return s.scanEntry(path, bufio.NewReader(r), s.newArchiveState(), fn)
+}
+
+// scanText scans the lines of a text file.
+func (s *Scanner) scanText(path string, reader *bufio.Reader, fn func(Finding)) error {
+	prev := ""
+	for lineNo := 1; ; lineNo++ {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimRight(line, "\r\n")
+			s.scanLine(path, lineNo, line, prev, fn)
+			prev = line
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// assignment matches the name a value is being assigned to at the end of
+// the text before it: NAME=, name: , "name": , name := or name => ,
+// optionally followed by an opening quote.
+var assignment = regexp.MustCompile("([A-Za-z_][A-Za-z0-9_.-]*)[\"']?\\s*(?::=|=>|[:=])\\s*[\"'`]?$")
+
+// scanLine scans a single line; prev is the line before it, used as context.
+func (s *Scanner) scanLine(path string, lineNo int, line, prev string, fn func(Finding)) {
+	s.findSecrets(line, nil, func(start, end int) Finding {
+		return locateFinding(path, lineNo, line, prev, start, end)
+	}, fn)
+}
+
+// locateFinding returns a finding for the text between start and end of a
+// line, with its position and context filled in.
+func locateFinding(path string, lineNo int, line, prev string, start, end int) Finding {
+	before, after := line[:start], line[end:]
+	finding := Finding{
+		Path:   path,
+		Line:   lineNo,
+		Column: utf8.RuneCountInString(before) + 1,
+		Nearby: before + after,
+	}
+	if m := assignment.FindStringSubmatch(before); m != nil {
+		finding.KeyName = m[1]
+	}
+	if finding.KeyName == "" || opensBlock(prev) {
+		finding.Nearby = prev + "\n" + finding.Nearby
+	}
+	return finding
+}
+
+// opensBlock reports whether line starts a nested block, such as a JSON
+// object, a YAML mapping or an INI section.
+func opensBlock(line string) bool {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+		return true
+	}
+	return strings.HasSuffix(line, "{") || strings.HasSuffix(line, ":") || strings.HasSuffix(line, "[")
+}
+
+func (s *Scanner) reportError(path string, err error) {
+	if s.OnError != nil {
+		s.OnError(path, err)
+	}
+}
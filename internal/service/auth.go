@@ -0,0 +1,260 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// applyAuth adds the service's built-in authentication to req. client is used
+// for schemes that need a request of their own, such as an OAuth2 token exchange.
+func applyAuth(client *http.Client, req *http.Request, auth *config.Auth, cred Credential, values map[string]string, payload []byte) error {
+	if auth == nil {
+		return nil
+	}
+
+	switch auth.Type {
+	case "basic":
+		fields, err := renderFields(cred, values, auth.Username, auth.Password)
+		if err != nil {
+			return err
+		}
+		req.SetBasicAuth(fields[0], fields[1])
+	case "bearer":
+		token, err := render(auth.Token, cred, values)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "query":
+		token, err := render(auth.Token, cred, values)
+		if err != nil {
+			return err
+		}
+		addQueryParam(req.URL, auth.Param, token)
+	case "hmac":
+		return signHMAC(req, auth, cred, values, payload)
+	case "oauth2_client_credentials":
+		fields, err := renderFields(cred, values, auth.TokenURL, auth.ClientID, auth.ClientSecret)
+		if err != nil {
+			return err
+		}
+		token, err := fetchOAuth2Token(req.Context(), client, fields[0], fields[1], fields[2], auth)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case "aws_sigv4":
+		creds, err := renderAWSCredentials(auth, cred, values)
+		if err != nil {
+			return err
+		}
+		signAWSv4(req, creds, payload)
+	default:
+		return fmt.Errorf("unknown auth type: %s", auth.Type)
+	}
+	return nil
+}
+
+func renderFields(cred Credential, values map[string]string, templates ...string) ([]string, error) {
+	rendered := make([]string, len(templates))
+	for i, text := range templates {
+		var err error
+		if rendered[i], err = render(text, cred, values); err != nil {
+			return nil, err
+		}
+	}
+	return rendered, nil
+}
+
+// addQueryParam appends name=value to u without reordering the existing query.
+func addQueryParam(u *url.URL, name, value string) {
+	param := url.QueryEscape(name) + "=" + url.QueryEscape(value)
+	if u.RawQuery == "" {
+		u.RawQuery = param
+	} else {
+		u.RawQuery += "&" + param
+	}
+}
+
+// signHMAC signs the rendered message with the rendered secret. Besides the
+// credential parts, the message template can use {{.method}}, {{.path}},
+// {{.query}}, {{.body}} and {{.timestamp}} (Unix seconds).
+func signHMAC(req *http.Request, auth *config.Auth, cred Credential, values map[string]string, payload []byte) error {
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+
+	data := make(map[string]string, len(values)+5)
+	for name, value := range values {
+		data[name] = value
+	}
+	data["method"] = req.Method
+	data["path"] = req.URL.EscapedPath()
+	data["query"] = req.URL.RawQuery
+	data["body"] = string(payload)
+	data["timestamp"] = timestamp
+
+	fields, err := renderFields(cred, data, auth.Secret, auth.Message)
+	if err != nil {
+		return err
+	}
+
+	var newHash func() hash.Hash
+	switch auth.Algorithm {
+	case "sha1":
+		newHash = sha1.New
+	case "sha512":
+		newHash = sha512.New
+	default:
+		newHash = sha256.New
+	}
+	mac := hmac.New(newHash, []byte(fields[0]))
+	mac.Write([]byte(fields[1]))
+
+	var signature string
+	if auth.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	} else {
+		signature = hex.EncodeToString(mac.Sum(nil))
+	}
+
+	if auth.TimestampHeader != "" {
+		req.Header.Set(auth.TimestampHeader, timestamp)
+	}
+	if auth.Header != "" {
+		req.Header.Set(auth.Header, signature)
+	} else {
+		addQueryParam(req.URL, auth.Param, signature)
+	}
+	return nil
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// oauth2Token is a cached access token. A zero expires means the token
+// endpoint didn't say when it expires.
+type oauth2Token struct {
+	accessToken string
+	expires     time.Time
+}
+
+// oauth2Tokens caches access tokens by token URL, client credentials and
+// scopes, so a verification and its capability probes exchange the client
+// credentials once.
+var oauth2Tokens sync.Map
+
+// oauth2ExpiryMargin is how long before it expires a cached token is
+// replaced, so it doesn't expire in flight.
+const oauth2ExpiryMargin = 30 * time.Second
+
+// fetchOAuth2Token exchanges client credentials for an access token, or
+// returns the token an earlier exchange got for them if it hasn't expired.
+func fetchOAuth2Token(ctx context.Context, client *http.Client, tokenURL, clientID, clientSecret string, auth *config.Auth) (string, error) {
+	key := strings.Join([]string{tokenURL, clientID, clientSecret, auth.ClientAuth, strings.Join(auth.Scopes, " ")}, "\x00")
+	if cached, ok := oauth2Tokens.Load(key); ok {
+		token := cached.(oauth2Token)
+		if token.expires.IsZero() || now().Before(token.expires) {
+			return token.accessToken, nil
+		}
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(auth.Scopes) > 0 {
+		form.Set("scope", strings.Join(auth.Scopes, " "))
+	}
+	if auth.ClientAuth == "body" {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if auth.ClientAuth != "body" {
+		req.SetBasicAuth(clientID, clientSecret)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token exchange failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("error reading token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", &statusCodeError{what: "token exchange", code: resp.StatusCode}
+	}
+
+	var token oauth2TokenResponse
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("error parsing token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token response has no access_token")
+	}
+
+	cached := oauth2Token{accessToken: token.AccessToken}
+	if token.ExpiresIn > 0 {
+		cached.expires = now().Add(time.Duration(token.ExpiresIn)*time.Second - oauth2ExpiryMargin)
+	}
+	oauth2Tokens.Store(key, cached)
+	return token.AccessToken, nil
+}
+
+func renderAWSCredentials(auth *config.Auth, cred Credential, values map[string]string) (awsCredentials, error) {
+	fields, err := renderFields(cred, values, auth.AccessKeyID, auth.SecretAccessKey, auth.SessionToken)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+
+	creds := awsCredentials{
+		AccessKeyID:     fields[0],
+		SecretAccessKey: fields[1],
+		SessionToken:    fields[2],
+		Region:          auth.Region,
+		Service:         auth.Service,
+	}
+	if creds.Region == "" {
+		creds.Region = defaultAWSRegion
+	}
+	if creds.Service == "" {
+		creds.Service = defaultAWSService
+	}
+	return creds, nil
+}
+
+// authDetails returns identity details reported by a built-in auth scheme
+// for a successful verification.
+func authDetails(auth *config.Auth, body []byte) map[string]string {
+	if auth == nil {
+		return nil
+	}
+	if auth.Type == "aws_sigv4" {
+		return parseCallerIdentity(body)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+func TestHMACSignature(t *testing.T) {
+	fixedClock(t, "20240101T000000Z")
+
+	// Signatures of "POST /v1/check?a=1 1704067200 ping" with the key
+	// "s3cr3t", computed independently.
+	tests := []struct {
+		name      string
+		auth      config.Auth
+		signature func(r *http.Request) string
+		want      string
+	}{
+		{
+			"sha256 hex in a header",
+			config.Auth{Header: "X-Signature", TimestampHeader: "X-Timestamp"},
+			func(r *http.Request) string {
+				if r.Header.Get("X-Timestamp") != "1704067200" {
+					return ""
+				}
+				return r.Header.Get("X-Signature")
+			},
+			"694882eeac6c22f819c542528764342296b96df5efa9a03b6250cba5c6e2ff72",
+		},
+		{
+			"sha1 base64 in a query parameter",
+			config.Auth{Param: "sig", Algorithm: "sha1", Encoding: "base64"},
+			func(r *http.Request) string { return r.URL.Query().Get("sig") },
+			"hAsmKOc53BQnXC+tSpUE5+VXddQ=",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := tt.signature(r); got != tt.want {
+					w.WriteHeader(http.StatusUnauthorized)
+					fmt.Fprintf(w, `{"error":"signature %q"}`, got)
+					return
+				}
+				fmt.Fprint(w, `{"ok":true}`)
+			}))
+			defer server.Close()
+
+			auth := tt.auth
+			auth.Type = "hmac"
+			auth.Secret = "{{.key}}"
+			auth.Message = "{{.method}} {{.path}}?{{.query}} {{.timestamp}} {{.body}}"
+			svc := testService(server.URL + "/v1/check?a=1")
+			svc.VerifyMethod = "POST"
+			svc.Body = "ping"
+			svc.BodyType = "raw"
+			svc.Auth = &auth
+
+			client, err := NewClient(config.HTTP{}, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"s3cr3t"}}, false)
+			if !result.Valid() {
+				t.Errorf("expected the signature to be accepted, got %s: %s", result.Status, result.Reason)
+			}
+		})
+	}
+}
+
+// newTokenServer returns a server with an OAuth2 token endpoint at /token,
+// which issues "token-1" to the client "id" with the secret "secret", and an
+// API at /api that accepts it. requests counts the token requests.
+func newTokenServer(t *testing.T) (server *httptest.Server, requests *int64) {
+	requests = new(int64)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		id, secret, _ := r.BasicAuth()
+		if r.Method != "POST" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if id != "id" || secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"token-1","token_type":"bearer","expires_in":3600}`)
+	})
+	mux.HandleFunc("/empty-token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(requests, 1)
+		fmt.Fprint(w, `{"token_type":"bearer"}`)
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func oauth2Service(tokenURL, apiURL string) config.Service {
+	svc := testService(apiURL)
+	svc.Credentials = []string{"client_id", "client_secret"}
+	svc.Auth = &config.Auth{
+		Type:         "oauth2_client_credentials",
+		TokenURL:     tokenURL,
+		ClientID:     "{{.client_id}}",
+		ClientSecret: "{{.client_secret}}",
+		Scopes:       []string{"read", "write"},
+	}
+	svc.Capabilities = []config.Capability{{
+		Name:       "read",
+		Severity:   "medium",
+		URL:        apiURL,
+		Method:     "GET",
+		Validation: config.Validation{StatusCode: 200, SuccessIndicator: config.SuccessIndicator{Type: "status_code_only"}},
+	}}
+	return svc
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	server, requests := newTokenServer(t)
+	svc := oauth2Service(server.URL+"/token", server.URL+"/api")
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"id", "secret"}}, false)
+		if !result.Valid() || len(result.Capabilities) != 1 || !result.Capabilities[0].Granted {
+			t.Fatalf("expected the exchanged token to be accepted, got %s: %s, %+v", result.Status, result.Reason, result.Capabilities)
+		}
+	}
+	if n := atomic.LoadInt64(requests); n != 1 {
+		t.Errorf("expected one token exchange for two verifications and their probes, got %d", n)
+	}
+}
+
+func TestOAuth2TokenExchangeErrors(t *testing.T) {
+	server, requests := newTokenServer(t)
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	svc := oauth2Service(server.URL+"/token", server.URL+"/api")
+	for i := 0; i < 2; i++ {
+		result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"id", "wrong"}}, false)
+		if result.Status != StatusInvalid || result.HTTPStatus != http.StatusUnauthorized || !strings.Contains(result.Reason, "token exchange") {
+			t.Errorf("expected a rejected exchange to make the key invalid, got %s (%d): %s", result.Status, result.HTTPStatus, result.Reason)
+		}
+	}
+	if n := atomic.LoadInt64(requests); n != 2 {
+		t.Errorf("expected rejected exchanges not to be cached, got %d token requests for 2", n)
+	}
+
+	svc = oauth2Service(server.URL+"/empty-token", server.URL+"/api")
+	result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"id", "secret"}}, false)
+	if result.Status != StatusError || !strings.Contains(result.Reason, "no access_token") {
+		t.Errorf("expected an error for a response without a token, got %s: %s", result.Status, result.Reason)
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+const (
+	testAccessKeyID     = "AKIDEXAMPLE"
+	testSecretAccessKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+)
+
+func fixedClock(t *testing.T, ts string) {
+	parsed, err := time.Parse(amzDateFormat, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = func() time.Time { return parsed }
+	t.Cleanup(func() { now = time.Now })
+}
+
+// Example request from the AWS Signature Version 4 documentation.
+func TestSignAWSv4KnownVector(t *testing.T) {
+	fixedClock(t, "20150830T123600Z")
+
+	req, err := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signAWSv4(req, awsCredentials{
+		AccessKeyID:     testAccessKeyID,
+		SecretAccessKey: testSecretAccessKey,
+		Region:          "us-east-1",
+		Service:         "iam",
+	}, nil)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+// stubAuthorization matches the Authorization header the stub STS expects:
+// the test key, the scope for 2024-01-01 in us-east-1 and a hex signature.
+// The signature itself is covered by TestSignAWSv4KnownVector.
+var stubAuthorization = regexp.MustCompile(`^AWS4-HMAC-SHA256 ` +
+	`Credential=` + testAccessKeyID + `/20240101/us-east-1/sts/aws4_request, ` +
+	`SignedHeaders=([a-z0-9;-]+), Signature=[0-9a-f]{64}$`)
+
+func TestVerifyKeyAgainstStubSTS(t *testing.T) {
+	fixedClock(t, "20240101T000000Z")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := stubAuthorization.FindStringSubmatch(r.Header.Get("Authorization"))
+		if m == nil {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<ErrorResponse><Error><Code>InvalidClientTokenId</Code></Error></ErrorResponse>")
+			return
+		}
+		if m[1] != "host;x-amz-date;x-amz-security-token" ||
+			r.Header.Get("X-Amz-Date") != "20240101T000000Z" ||
+			r.Header.Get("X-Amz-Security-Token") != "session" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		fmt.Fprint(w, `<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <GetCallerIdentityResult>
+    <Arn>arn:aws:iam::123456789012:user/alice</Arn>
+    <UserId>AIDAEXAMPLE</UserId>
+    <Account>123456789012</Account>
+  </GetCallerIdentityResult>
+</GetCallerIdentityResponse>`)
+	}))
+	defer server.Close()
+
+	svc := config.Service{
+		Name:         "AWS",
+		Credentials:  []string{"access_key_id", "secret_access_key", "session_token?"},
+		VerifyURL:    server.URL + "/?Action=GetCallerIdentity&Version=2011-06-15",
+		VerifyMethod: "POST",
+		Auth: &config.Auth{
+			Type:            "aws_sigv4",
+			AccessKeyID:     "{{.access_key_id}}",
+			SecretAccessKey: "{{.secret_access_key}}",
+			SessionToken:    "{{.session_token}}",
+		},
+		Validation: config.Validation{
+			StatusCode:       200,
+			SuccessIndicator: config.SuccessIndicator{Type: "contains_string", Value: "<GetCallerIdentityResponse"},
+		},
+	}
+
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{testAccessKeyID, testSecretAccessKey, "session"}}, false)
+	if !result.Valid() {
+		t.Fatalf("expected signed request to be accepted by stub STS, got %s: %s", result.Status, result.Reason)
+	}
+	if result.Details["arn"] != "arn:aws:iam::123456789012:user/alice" || result.Details["account"] != "123456789012" {
+		t.Errorf("unexpected caller identity: %v", result.Details)
+	}
+
+	result = VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"AKIDUNKNOWN", testSecretAccessKey, "session"}}, false)
+	if result.Status != StatusInvalid || result.HTTPStatus != http.StatusForbidden {
+		t.Errorf("expected an unknown access key to be rejected, got %s (%d)", result.Status, result.HTTPStatus)
+	}
+}
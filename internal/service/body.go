@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+)
+
+// renderBody builds a request body and the Content-Type it implies. String
+// values anywhere in the body are expanded as templates. Mappings default to
+// JSON and strings to raw bodies when bodyType is unset.
+func renderBody(body interface{}, bodyType string, cred Credential, values map[string]string) ([]byte, string, error) {
+	if body == nil {
+		return nil, "", nil
+	}
+
+	if bodyType == "" {
+		if _, ok := body.(string); ok {
+			bodyType = "raw"
+		} else {
+			bodyType = "json"
+		}
+	}
+
+	rendered, err := renderValue(body, cred, values)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch bodyType {
+	case "raw":
+		text, ok := rendered.(string)
+		if !ok {
+			return nil, "", fmt.Errorf("raw body must be a string")
+		}
+		return []byte(text), "", nil
+	case "form":
+		fields, ok := rendered.(map[string]interface{})
+		if !ok {
+			return nil, "", fmt.Errorf("form body must be a mapping")
+		}
+		return []byte(encodeForm(fields)), "application/x-www-form-urlencoded", nil
+	case "json":
+		// A string is taken to be JSON already, e.g. a hand-written GraphQL payload.
+		if text, ok := rendered.(string); ok {
+			return []byte(text), "application/json", nil
+		}
+		data, err := json.Marshal(rendered)
+		if err != nil {
+			return nil, "", fmt.Errorf("error encoding JSON body: %w", err)
+		}
+		return data, "application/json", nil
+	default:
+		return nil, "", fmt.Errorf("unknown body type: %s", bodyType)
+	}
+}
+
+// renderValue expands templates in every string of a YAML value and converts
+// YAML mappings into JSON-friendly map[string]interface{} values.
+func renderValue(value interface{}, cred Credential, values map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return render(v, cred, values)
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			rendered, err := renderValue(item, cred, values)
+			if err != nil {
+				return nil, err
+			}
+			out[fmt.Sprintf("%v", key)] = rendered
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := renderValue(item, cred, values)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = rendered
+		}
+		return out, nil
+	default:
+		return v, nil
+	}
+}
+
+func encodeForm(fields map[string]interface{}) string {
+	form := url.Values{}
+	for key, value := range fields {
+		form.Set(key, fmt.Sprintf("%v", value))
+	}
+	return form.Encode()
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// CapabilityResult is the outcome of probing one capability of a valid key.
+type CapabilityResult struct {
+	Name        string
+	Description string
+	Severity    string
+	Granted     bool
+	// Reason explains why the probe couldn't be completed, if it failed
+	// before getting an answer.
+	Reason string
+}
+
+// probeCapabilities runs the service's capability probes for a verified key.
+// It returns their results and the key's severity: the most severe of the
+// service's own rating and those of the granted capabilities.
+func probeCapabilities(ctx context.Context, client *http.Client, service config.Service, cred Credential, values map[string]string, verbose bool) ([]CapabilityResult, string) {
+	severity := service.Severity
+	if len(service.Capabilities) == 0 {
+		return nil, severity
+	}
+
+	results := make([]CapabilityResult, 0, len(service.Capabilities))
+	for _, capability := range service.Capabilities {
+		result := CapabilityResult{
+			Name:        capability.Name,
+			Description: capability.Description,
+			Severity:    capability.Severity,
+		}
+
+		resp, body, err := doStep(ctx, client, service.CapabilityStep(capability), cred, values)
+		if err != nil {
+			result.Reason = err.Error()
+			logError(fmt.Sprintf("%s: capability %s: %v", service.Name, capability.Name, err), verbose)
+		} else {
+			probe := config.Service{Name: service.Name, Validation: capability.Validation}
+			result.Granted, _ = isValidResponse(probe, resp.StatusCode, resp.Header, body, verbose)
+		}
+
+		if result.Granted && config.SeverityRank(result.Severity) > config.SeverityRank(severity) {
+			severity = result.Severity
+		}
+		results = append(results, result)
+	}
+	return results, severity
+}
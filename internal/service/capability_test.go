@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+func TestProbeCapabilities(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	mux.HandleFunc("/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer admin-key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"repos":[]}`)
+	})
+	mux.HandleFunc("/billing", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	mux.HandleFunc("/settings", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"admin":false}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	probe := func(name, severity, path string, indicator config.SuccessIndicator) config.Capability {
+		return config.Capability{
+			Name:       name,
+			Severity:   severity,
+			URL:        server.URL + path,
+			Method:     "GET",
+			Validation: config.Validation{StatusCode: 200, SuccessIndicator: indicator},
+		}
+	}
+	svc := testService(server.URL + "/me")
+	svc.Headers = map[string]string{"Authorization": "Bearer %s"}
+	svc.Severity = "low"
+	svc.Capabilities = []config.Capability{
+		probe("list_repos", "high", "/repos", config.SuccessIndicator{Type: "json_key_exists", Key: "repos"}),
+		probe("read_billing", "critical", "/billing", config.SuccessIndicator{Type: "status_code_only"}),
+		probe("admin", "critical", "/settings", config.SuccessIndicator{Type: "json_key_value", Key: "admin", Value: "true"}),
+	}
+
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key      string
+		granted  []bool
+		severity string
+	}{
+		{"admin-key", []bool{true, false, false}, "high"},
+		{"read-only-key", []bool{false, false, false}, "low"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{tt.key}}, false)
+			if !result.Valid() {
+				t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+			}
+			if len(result.Capabilities) != len(tt.granted) {
+				t.Fatalf("expected %d capability results, got %+v", len(tt.granted), result.Capabilities)
+			}
+			for i, capability := range result.Capabilities {
+				if capability.Name != svc.Capabilities[i].Name || capability.Granted != tt.granted[i] {
+					t.Errorf("capability %d = %s granted %v, want %s granted %v",
+						i, capability.Name, capability.Granted, svc.Capabilities[i].Name, tt.granted[i])
+				}
+			}
+			if result.Severity != tt.severity {
+				t.Errorf("Severity = %q, want %q", result.Severity, tt.severity)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// Credential is a single key as supplied by the user. Most services take one
+// part, but some need several related values (an account ID and a secret, an
+// app ID and a key). The first part is the one matched against service regexes.
+// Params holds user-supplied service parameters such as a tenant subdomain.
+type Credential struct {
+	Parts  []string
+	Params map[string]string
+	// KeyNames are the names the key was assigned to where it was found,
+	// such as TWILIO_AUTH_TOKEN, and Nearby is text around it. Both are
+	// matched against the services' context keywords when ranking.
+	KeyNames []string
+	Nearby   []string
+}
+
+var annotationRegex = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)=(.*)$`)
+
+// ParseCredential splits input on delimiter into the parts of a credential.
+// An empty delimiter keeps the whole input as a single part. Trailing
+// whitespace-separated name=value annotations become parameters, e.g.
+// "your_api_token subdomain=acme".
+func ParseCredential(input, delimiter string) Credential {
+	params := make(map[string]string)
+	fields := strings.Fields(input)
+	for len(fields) > 1 {
+		match := annotationRegex.FindStringSubmatch(fields[len(fields)-1])
+		if match == nil {
+			break
+		}
+		if _, seen := params[match[1]]; !seen {
+			params[match[1]] = match[2]
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if len(params) > 0 {
+		input = strings.Join(fields, " ")
+	}
+
+	if delimiter == "" {
+		return Credential{Parts: []string{strings.TrimSpace(input)}, Params: params}
+	}
+
+	var parts []string
+	for _, part := range strings.Split(input, delimiter) {
+		parts = append(parts, strings.TrimSpace(part))
+	}
+	return Credential{Parts: parts, Params: params}
+}
+
+// JoinArgs joins the parts of a credential given as separate command line
+// arguments with delimiter, as they would appear on a line of a list file.
+// Trailing name=value arguments are appended after a space instead, so that
+// ParseCredential reads them as annotations rather than as the end of the
+// last part.
+func JoinArgs(args []string, delimiter string) string {
+	n := len(args)
+	for n > 1 && annotationRegex.MatchString(args[n-1]) {
+		n--
+	}
+	return strings.Join(append([]string{strings.Join(args[:n], delimiter)}, args[n:]...), " ")
+}
+
+// WithParams returns a copy of c with defaults added for any parameters c
+// doesn't set itself.
+func (c Credential) WithParams(defaults map[string]string) Credential {
+	params := make(map[string]string, len(c.Params)+len(defaults))
+	for name, value := range defaults {
+		params[name] = value
+	}
+	for name, value := range c.Params {
+		params[name] = value
+	}
+	c.Params = params
+	return c
+}
+
+// Key returns the primary part of the credential.
+func (c Credential) Key() string {
+	if len(c.Parts) == 0 {
+		return ""
+	}
+	return c.Parts[0]
+}
+
+// param resolves a service parameter from the credential's own parameters,
+// then the environment, then the parameter's default.
+func (c Credential) param(param config.Parameter) (string, bool) {
+	if value, ok := c.Params[param.Name]; ok && value != "" {
+		return value, true
+	}
+	if value := os.Getenv(param.EnvVar()); value != "" {
+		return value, true
+	}
+	if param.Default != "" {
+		return param.Default, true
+	}
+	return "", false
+}
+
+// MissingParameters returns the service parameters that have no value for
+// cred. Such keys can't be verified until the user supplies them.
+func MissingParameters(service config.Service, cred Credential) []config.Parameter {
+	var missing []config.Parameter
+	for _, param := range service.Parameters {
+		if _, ok := cred.param(param); !ok {
+			missing = append(missing, param)
+		}
+	}
+	return missing
+}
+
+// values maps the credential parts and parameters onto the names declared by
+// the service.
+func (c Credential) values(service config.Service) (map[string]string, error) {
+	names := service.CredentialNames()
+	if required := service.RequiredCredentials(); len(c.Parts) < required {
+		return nil, fmt.Errorf("requires %d credential parts (%s), got %d",
+			required, strings.Join(names, ", "), len(c.Parts))
+	}
+
+	values := make(map[string]string, len(names))
+	for i, name := range names {
+		if i < len(c.Parts) {
+			values[name] = c.Parts[i]
+		} else {
+			values[name] = ""
+		}
+	}
+
+	for _, param := range service.Parameters {
+		value, ok := c.param(param)
+		if !ok {
+			return nil, fmt.Errorf("needs parameter %s", param.Name)
+		}
+		values[param.Name] = value
+	}
+	return values, nil
+}
+
+// render expands a verify_url, header or body value. The legacy %s placeholder is
+// replaced with the primary credential part; named parts are available as
+// template fields, e.g. {{.id}} or {{base64 (printf "%s:%s" .id .secret)}}.
+func render(text string, cred Credential, values map[string]string) (string, error) {
+	tmpl, err := config.ParseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	if tmpl == nil {
+		return strings.ReplaceAll(text, "%s", cred.Key()), nil
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", fmt.Errorf("error executing template %q: %w", text, err)
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,33 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJoinArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		delimiter string
+		parts     []string
+		params    map[string]string
+	}{
+		{"single key", []string{"key"}, ",", []string{"key"}, map[string]string{}},
+		{"parts", []string{"id", "secret"}, ",", []string{"id", "secret"}, map[string]string{}},
+		{"annotation", []string{"key", "subdomain=acme"}, ",", []string{"key"}, map[string]string{"subdomain": "acme"}},
+		{"parts and annotations", []string{"id", "secret", "subdomain=acme", "region=eu"}, ",",
+			[]string{"id", "secret"}, map[string]string{"subdomain": "acme", "region": "eu"}},
+		{"annotation without a delimiter", []string{"key", "subdomain=acme"}, "", []string{"key"}, map[string]string{"subdomain": "acme"}},
+		{"key that looks like an annotation", []string{"abc=="}, ",", []string{"abc=="}, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := ParseCredential(JoinArgs(tt.args, tt.delimiter), tt.delimiter)
+			if !reflect.DeepEqual(cred.Parts, tt.parts) || !reflect.DeepEqual(cred.Params, tt.params) {
+				t.Errorf("ParseCredential(JoinArgs(%q, %q)) = %q %v, want %q %v",
+					tt.args, tt.delimiter, cred.Parts, cred.Params, tt.parts, tt.params)
+			}
+		})
+	}
+}
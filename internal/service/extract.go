@@ -0,0 +1,119 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+	"github.com/harshinsecurity/mantramatch/internal/jsonpath"
+)
+
+// response holds a response for extraction, decoding the JSON body at most
+// once however many extractors need it.
+type response struct {
+	headers    http.Header
+	body       []byte
+	parsed     interface{}
+	parseErr   error
+	parsedJSON bool
+}
+
+func (r *response) json() (interface{}, error) {
+	if !r.parsedJSON {
+		if err := json.Unmarshal(r.body, &r.parsed); err != nil {
+			r.parseErr = fmt.Errorf("error parsing JSON response: %w", err)
+		}
+		r.parsedJSON = true
+	}
+	return r.parsed, r.parseErr
+}
+
+// extractValues applies a step's extractors to its response. Every extractor
+// must match.
+func extractValues(extractors map[string]config.Extractor, headers http.Header, body []byte) (map[string]string, error) {
+	if len(extractors) == 0 {
+		return nil, nil
+	}
+
+	resp := &response{headers: headers, body: body}
+	values := make(map[string]string, len(extractors))
+	for name, extractor := range extractors {
+		value, err := extractValue(extractor, resp)
+		if err != nil {
+			return nil, fmt.Errorf("%s for %s", err, name)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// extractMetadata applies a service's extract section to a successful
+// response. Fields that aren't present are left out rather than failing
+// the verification.
+func extractMetadata(extractors map[string]config.Extractor, headers http.Header, body []byte, verbose bool) map[string]string {
+	if len(extractors) == 0 {
+		return nil
+	}
+
+	resp := &response{headers: headers, body: body}
+	values := make(map[string]string, len(extractors))
+	for name, extractor := range extractors {
+		value, err := extractValue(extractor, resp)
+		if err != nil {
+			logError(fmt.Sprintf("%s for %s", err, name), verbose)
+			continue
+		}
+		values[name] = value
+	}
+	return values
+}
+
+func extractValue(extractor config.Extractor, resp *response) (string, error) {
+	switch {
+	case extractor.Header != "":
+		value := resp.headers.Get(extractor.Header)
+		if value == "" {
+			return "", fmt.Errorf("header %s not found", extractor.Header)
+		}
+		return value, nil
+	case extractor.Regex != "":
+		regex, err := extractor.Regexp()
+		if err != nil {
+			return "", err
+		}
+		match := regex.FindSubmatch(resp.body)
+		if match == nil {
+			return "", fmt.Errorf("regex %q did not match", extractor.Regex)
+		}
+		return string(match[len(match)-1]), nil
+	case extractor.JSON != "":
+		parsed, err := resp.json()
+		if err != nil {
+			return "", err
+		}
+		path, err := jsonpath.Compile(extractor.JSON)
+		if err != nil {
+			return "", err
+		}
+		value, ok := path.First(parsed)
+		if !ok {
+			return "", fmt.Errorf("JSON path %s not found", extractor.JSON)
+		}
+		return jsonString(value), nil
+	}
+	return "", fmt.Errorf("empty extractor")
+}
+
+// jsonString renders a decoded JSON value as a plain string: strings as-is,
+// everything else in its JSON form.
+func jsonString(value interface{}) string {
+	if text, ok := value.(string); ok {
+		return text
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
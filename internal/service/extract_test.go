@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+func TestVerifyKeyMultiStep(t *testing.T) {
+	var verified int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		// The key names the part of the response to leave out.
+		key := r.URL.Query().Get("key")
+		if key != "no-session" {
+			w.Header().Set("X-Session", "sess-9")
+		}
+		csrf, tenants := `"csrf":"abc123",`, `[{"id":"t-42"}]`
+		if key == "no-csrf" {
+			csrf = ""
+		}
+		if key == "no-tenant" {
+			tenants = `[]`
+		}
+		fmt.Fprintf(w, `{%s"data":{"tenants":%s}}`, csrf, tenants)
+	})
+	mux.HandleFunc("/tenants/t-42/me", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&verified, 1)
+		if r.Header.Get("X-Session") != "sess-9" || r.Header.Get("X-CSRF") != "abc123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	svc := testService(server.URL + "/tenants/{{.tenant}}/me")
+	svc.Steps = []config.Step{{
+		Name:   "login",
+		URL:    server.URL + "/login?key={{.key}}",
+		Method: "POST",
+		Extract: map[string]config.Extractor{
+			"tenant":  {JSON: "data.tenants[0].id"},
+			"session": {Header: "X-Session"},
+			"csrf":    {Regex: `"csrf":"([a-z0-9]+)"`},
+		},
+	}}
+	svc.Headers = map[string]string{"X-Session": "{{.session}}", "X-CSRF": "{{.csrf}}"}
+
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false)
+	if !result.Valid() {
+		t.Fatalf("expected the values extracted by the login step to verify the key, got %s: %s", result.Status, result.Reason)
+	}
+
+	tests := []struct {
+		key    string
+		reason string
+	}{
+		{"no-tenant", "login: JSON path data.tenants[0].id not found for tenant"},
+		{"no-session", "login: header X-Session not found for session"},
+		{"no-csrf", `login: regex "\"csrf\":\"([a-z0-9]+)\"" did not match for csrf`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			before := atomic.LoadInt64(&verified)
+			result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{tt.key}}, false)
+			if result.Status != StatusInvalid || result.Reason != tt.reason {
+				t.Errorf("got %s: %s, want invalid: %s", result.Status, result.Reason, tt.reason)
+			}
+			if atomic.LoadInt64(&verified) != before {
+				t.Errorf("expected no verification request after a step failed to extract")
+			}
+		})
+	}
+}
+
+func TestVerifyKeyExtractsMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-OAuth-Scopes", "repo, read:org")
+		fmt.Fprint(w, `{"ok":true,"account":{"name":"acme","seats":12},"owner":"<alice@example.com>"}`)
+	}))
+	defer server.Close()
+
+	svc := testService(server.URL)
+	svc.Extract = map[string]config.Extractor{
+		"account": {JSON: "account.name"},
+		"seats":   {JSON: "account.seats"},
+		"scopes":  {Header: "X-OAuth-Scopes"},
+		"owner":   {Regex: `<([^>]+)>`},
+		"plan":    {JSON: "account.plan"},
+	}
+
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false)
+	if !result.Valid() {
+		t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+	}
+	want := map[string]string{"account": "acme", "seats": "12", "scopes": "repo, read:org", "owner": "alice@example.com"}
+	if !reflect.DeepEqual(result.Details, want) {
+		t.Errorf("Details = %v, want %v without the missing plan", result.Details, want)
+	}
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/harshinsecurity/mantramatch/internal/ahocorasick"
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// Matcher finds the services whose regex matches a key. Patterns are
+// compiled once, so a Matcher should be built once and shared; it is safe
+// for concurrent use.
+//
+// Before running any regex, a Matcher scans the key once for the literals
+// each service requires (its keywords, or literals derived from its regex,
+// such as "sk_live_"). Only services whose literals occur, plus those with
+// none, are candidates for the full regex.
+type Matcher struct {
+	services []config.Service
+	patterns []*regexp.Regexp
+	// unanchored holds each pattern with its ^ and $ anchors removed, for
+	// finding keys embedded in text.
+	unanchored []*regexp.Regexp
+
+	// specificity is the length of the shortest literal each service
+	// requires, 0 for generic patterns.
+	specificity []int
+
+	literals *ahocorasick.Automaton
+	// owners maps each literal to the services requiring it.
+	owners [][]int
+	// always lists the services checked against every key.
+	always []int
+}
+
+// NewMatcher builds a Matcher over services, using the patterns compiled by
+// config.LoadConfig and compiling any that haven't been.
+func NewMatcher(services []config.Service) (*Matcher, error) {
+	m := &Matcher{
+		services:    services,
+		patterns:    make([]*regexp.Regexp, len(services)),
+		unanchored:  make([]*regexp.Regexp, len(services)),
+		specificity: make([]int, len(services)),
+	}
+
+	var literals []string
+	index := make(map[string]int)
+	for i, service := range services {
+		pattern := service.Pattern()
+		if pattern == nil {
+			var err error
+			if pattern, err = regexp.Compile(service.Regex); err != nil {
+				return nil, fmt.Errorf("invalid regex for service '%s': %w", service.Name, err)
+			}
+		}
+		m.patterns[i] = pattern
+
+		unanchored, err := unanchor(service.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for service '%s': %w", service.Name, err)
+		}
+		m.unanchored[i] = unanchored
+
+		required := requiredLiterals(service.Regex)
+		if len(service.Keywords) > 0 {
+			required = nil
+			for _, keyword := range service.Keywords {
+				required = append(required, strings.ToLower(keyword))
+			}
+		}
+		if len(required) == 0 {
+			m.always = append(m.always, i)
+			continue
+		}
+		m.specificity[i] = shortest(required)
+		for _, literal := range required {
+			n, ok := index[literal]
+			if !ok {
+				n = len(literals)
+				index[literal] = n
+				literals = append(literals, literal)
+				m.owners = append(m.owners, nil)
+			}
+			m.owners[n] = append(m.owners[n], i)
+		}
+	}
+	m.literals = ahocorasick.New(literals)
+
+	return m, nil
+}
+
+// Match returns the services whose regex matches key, in configuration order.
+func (m *Matcher) Match(key string) []config.Service {
+	var matches []config.Service
+	for _, i := range m.match(key) {
+		matches = append(matches, m.services[i])
+	}
+	return matches
+}
+
+// match returns the indexes of the services whose regex matches key.
+func (m *Matcher) match(key string) []int {
+	var matches []int
+	for i, candidate := range m.candidates(key) {
+		if candidate && m.patterns[i].MatchString(key) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
+}
+
+// candidates reports, for each service, whether text contains one of the
+// literals it requires.
+func (m *Matcher) candidates(text string) []bool {
+	candidates := make([]bool, len(m.services))
+	for _, i := range m.always {
+		candidates[i] = true
+	}
+	m.literals.Each(strings.ToLower(text), func(literal int) {
+		for _, i := range m.owners[literal] {
+			candidates[i] = true
+		}
+	})
+	return candidates
+}
+
+// Hit is the position of a possible key within a larger text.
+type Hit struct {
+	Start, End int
+}
+
+// Find returns the distinct spans of text matching any service's regex with
+// its anchors removed, ordered by position. A span must not start or end in
+// the middle of a word, so a 32-character pattern doesn't fire inside a
+// 40-character token.
+func (m *Matcher) Find(text string) []Hit {
+	seen := make(map[Hit]bool)
+	var hits []Hit
+	for i, candidate := range m.candidates(text) {
+		if !candidate {
+			continue
+		}
+		for _, loc := range m.unanchored[i].FindAllStringIndex(text, -1) {
+			hit := Hit{Start: loc[0], End: loc[1]}
+			if hit.Start == hit.End || seen[hit] || !atBoundary(text, hit) {
+				continue
+			}
+			seen[hit] = true
+			hits = append(hits, hit)
+		}
+	}
+	sort.Slice(hits, func(a, b int) bool {
+		if hits[a].Start != hits[b].Start {
+			return hits[a].Start < hits[b].Start
+		}
+		return hits[a].End < hits[b].End
+	})
+	return hits
+}
+
+// atBoundary reports whether hit neither continues a word before it nor runs
+// into one after it.
+func atBoundary(text string, hit Hit) bool {
+	if hit.Start > 0 && isWordByte(text[hit.Start-1]) && isWordByte(text[hit.Start]) {
+		return false
+	}
+	if hit.End < len(text) && isWordByte(text[hit.End-1]) && isWordByte(text[hit.End]) {
+		return false
+	}
+	return true
+}
+
+func isWordByte(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '_' || b == '-'
+}
+
+// unanchor compiles pattern with its leading ^ and trailing $ removed,
+// including those inside top-level alternatives.
+func unanchor(pattern string) (*regexp.Regexp, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(stripAnchors(re).String())
+}
+
+func stripAnchors(re *syntax.Regexp) *syntax.Regexp {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return &syntax.Regexp{Op: syntax.OpEmptyMatch}
+	case syntax.OpCapture, syntax.OpAlternate:
+		for i, sub := range re.Sub {
+			re.Sub[i] = stripAnchors(sub)
+		}
+	case syntax.OpConcat:
+		subs := re.Sub
+		for len(subs) > 0 && isAnchor(subs[0]) {
+			subs = subs[1:]
+		}
+		for len(subs) > 0 && isAnchor(subs[len(subs)-1]) {
+			subs = subs[:len(subs)-1]
+		}
+		if len(subs) > 0 {
+			subs[0] = stripAnchors(subs[0])
+			subs[len(subs)-1] = stripAnchors(subs[len(subs)-1])
+		}
+		re.Sub = subs
+	}
+	return re
+}
+
+func isAnchor(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpEndText, syntax.OpBeginLine, syntax.OpEndLine:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// benchmarkServices returns the bundled catalog plus enough synthetic
+// prefixed services to resemble a catalog of several hundred entries.
+func benchmarkServices(tb testing.TB) []config.Service {
+	cfg, err := config.LoadConfig("../../config.yaml")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	services := cfg.Services
+	for i := 0; i < 500; i++ {
+		services = append(services, config.Service{
+			Name:  fmt.Sprintf("Synthetic %d", i),
+			Regex: fmt.Sprintf(`^syn%03d_[a-zA-Z0-9]{32}$`, i),
+		})
+	}
+	return services
+}
+
+func randomString(r *rand.Rand, alphabet string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = alphabet[r.Intn(len(alphabet))]
+	}
+	return string(b)
+}
+
+func benchmarkKeys() []string {
+	const alnum = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	r := rand.New(rand.NewSource(1))
+	var keys []string
+	for i := 0; i < 50; i++ {
+		keys = append(keys,
+			randomString(r, alnum, 32),
+			randomString(r, "0123456789abcdef", 40),
+			"sk_live_"+randomString(r, alnum, 24),
+			"ghp_"+randomString(r, alnum, 36),
+			"AKIA"+randomString(r, "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789", 16),
+			"GHP_"+randomString(r, alnum, 36),
+			fmt.Sprintf("syn%03d_%s", r.Intn(500), randomString(r, alnum, 32)),
+		)
+	}
+	return keys
+}
+
+// linearMatch is the behaviour the prefilter replaces: every regex is run
+// against every key.
+func linearMatch(services []config.Service, patterns []*regexp.Regexp, key string) []config.Service {
+	var matches []config.Service
+	for i, pattern := range patterns {
+		if pattern.MatchString(key) {
+			matches = append(matches, services[i])
+		}
+	}
+	return matches
+}
+
+func compilePatterns(services []config.Service) []*regexp.Regexp {
+	patterns := make([]*regexp.Regexp, len(services))
+	for i, service := range services {
+		patterns[i] = regexp.MustCompile(service.Regex)
+	}
+	return patterns
+}
+
+func serviceNames(services []config.Service) []string {
+	var names []string
+	for _, service := range services {
+		names = append(names, service.Name)
+	}
+	return names
+}
+
+func TestMatcherAgreesWithLinearScan(t *testing.T) {
+	services := benchmarkServices(t)
+	patterns := compilePatterns(services)
+	matcher, err := NewMatcher(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range benchmarkKeys() {
+		got := serviceNames(matcher.Match(key))
+		want := serviceNames(linearMatch(services, patterns, key))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Match(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRequiredLiterals(t *testing.T) {
+	tests := []struct {
+		regex string
+		want  []string
+	}{
+		{`^sk_live_[0-9a-zA-Z]{24}$`, []string{"sk_live_"}},
+		{`^(AKIA|ASIA)[0-9A-Z]{16}$`, []string{"akia", "asia"}},
+		{`^gh[pousr]_[A-Za-z0-9_]{36}$`, []string{"ghp_", "gho_", "ghu_", "ghs_", "ghr_"}},
+		{`^[a-f0-9]{32}$`, nil},
+		{`^(?i)secret_[a-z]+$`, nil},
+		{`^(?i)token_[a-z]+$`, nil},
+		{`^(?i)npm_[a-z]+$`, []string{"npm_"}},
+		{`^x?abc$`, []string{"abc"}},
+	}
+	for _, tt := range tests {
+		got := requiredLiterals(tt.regex)
+		if len(got) != len(tt.want) {
+			t.Errorf("requiredLiterals(%q) = %q, want %q", tt.regex, got, tt.want)
+			continue
+		}
+		for _, literal := range tt.want {
+			found := false
+			for _, g := range got {
+				found = found || g == literal
+			}
+			if !found {
+				t.Errorf("requiredLiterals(%q) = %q, want %q", tt.regex, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestUnanchor(t *testing.T) {
+	tests := []struct {
+		regex string
+		text  string
+		want  string
+	}{
+		{`^sk_live_[0-9a-z]{4}$`, "key=sk_live_abcd;", "sk_live_abcd"},
+		{`^(AKIA|ASIA)[0-9A-Z]{4}$`, "id ASIAWXYZ", "ASIAWXYZ"},
+		{`^sk_[a-z]{4}$|^pk_[a-z]{4}$`, "x pk_abcd y", "pk_abcd"},
+		{`^sk_[a-z]{4}$|^pk_[a-z]{4}$`, "x sk_abcd y", "sk_abcd"},
+		{`^[0-9]{4}$|^key-[a-z]{4}$`, "(key-abcd)", "key-abcd"},
+		{`(?m)^xoxb-[0-9]{4}$`, "token: xoxb-1234 ", "xoxb-1234"},
+	}
+	for _, tt := range tests {
+		re, err := unanchor(tt.regex)
+		if err != nil {
+			t.Fatalf("unanchor(%q): %v", tt.regex, err)
+		}
+		if got := re.FindString(tt.text); got != tt.want {
+			t.Errorf("unanchor(%q) = %s, found %q in %q, want %q", tt.regex, re, got, tt.text, tt.want)
+		}
+	}
+}
+
+func BenchmarkMatchLinear(b *testing.B) {
+	services := benchmarkServices(b)
+	patterns := compilePatterns(services)
+	keys := benchmarkKeys()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearMatch(services, patterns, keys[i%len(keys)])
+	}
+}
+
+func BenchmarkMatchPrefilter(b *testing.B) {
+	services := benchmarkServices(b)
+	matcher, err := NewMatcher(services)
+	if err != nil {
+		b.Fatal(err)
+	}
+	keys := benchmarkKeys()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		matcher.Match(keys[i%len(keys)])
+	}
+}
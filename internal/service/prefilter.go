@@ -0,0 +1,190 @@
+package service
+
+import (
+	"regexp/syntax"
+	"strings"
+)
+
+const (
+	// minLiteralLen is the shortest literal worth prefiltering on. Services
+	// whose regex has nothing longer are checked against every key.
+	minLiteralLen = 3
+	// maxLiteralSet caps how many alternative literals are tracked for a
+	// pattern, and maxClassSize how large a character class is expanded.
+	maxLiteralSet = 64
+	maxClassSize  = 10
+)
+
+// requiredLiterals returns lower-cased strings such that every match of
+// pattern contains at least one of them, or nil if no useful set exists.
+func requiredLiterals(pattern string) []string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	literals := required(re.Simplify())
+	if shortest(literals) < minLiteralLen {
+		return nil
+	}
+	return literals
+}
+
+// required returns a set of strings one of which occurs in every match of
+// re, or nil.
+func required(re *syntax.Regexp) []string {
+	if set, ok := exact(re); ok {
+		if shortest(set) == 0 {
+			return nil
+		}
+		return set
+	}
+
+	switch re.Op {
+	case syntax.OpCapture, syntax.OpPlus:
+		return required(re.Sub[0])
+	case syntax.OpAlternate:
+		var set []string
+		for _, sub := range re.Sub {
+			literals := required(sub)
+			if literals == nil {
+				return nil
+			}
+			set = union(set, literals)
+		}
+		if len(set) > maxLiteralSet {
+			return nil
+		}
+		return set
+	case syntax.OpConcat:
+		// Adjacent sub-expressions with a small exact language are joined
+		// into runs; the best run or sub-expression wins.
+		var best []string
+		run := []string{""}
+		for _, sub := range re.Sub {
+			if set, ok := exact(sub); ok {
+				if joined, ok := cross(run, set); ok {
+					run = joined
+				} else {
+					best = better(best, run)
+					run = set
+				}
+				continue
+			}
+			best = better(best, run)
+			run = []string{""}
+			best = better(best, required(sub))
+		}
+		return better(best, run)
+	}
+	return nil
+}
+
+// exact returns the complete, lower-cased language of re if it is small.
+func exact(re *syntax.Regexp) ([]string, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine,
+		syntax.OpBeginText, syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return []string{""}, true
+	case syntax.OpLiteral:
+		// Case folding maps 'k' and 's' to runes that strings.ToLower keeps
+		// distinct (the Kelvin sign and long s), so those can't be lowered.
+		if re.Flags&syntax.FoldCase != 0 && strings.ContainsAny(string(re.Rune), "kKsS") {
+			return nil, false
+		}
+		return []string{strings.ToLower(string(re.Rune))}, true
+	case syntax.OpCharClass:
+		size := 0
+		for i := 0; i < len(re.Rune); i += 2 {
+			size += int(re.Rune[i+1]-re.Rune[i]) + 1
+			if size > maxClassSize {
+				return nil, false
+			}
+		}
+		var set []string
+		for i := 0; i < len(re.Rune); i += 2 {
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				set = union(set, []string{strings.ToLower(string(r))})
+			}
+		}
+		return set, true
+	case syntax.OpCapture:
+		return exact(re.Sub[0])
+	case syntax.OpConcat:
+		set := []string{""}
+		for _, sub := range re.Sub {
+			literals, ok := exact(sub)
+			if !ok {
+				return nil, false
+			}
+			if set, ok = cross(set, literals); !ok {
+				return nil, false
+			}
+		}
+		return set, true
+	case syntax.OpAlternate:
+		var set []string
+		for _, sub := range re.Sub {
+			literals, ok := exact(sub)
+			if !ok {
+				return nil, false
+			}
+			set = union(set, literals)
+		}
+		return set, len(set) <= maxLiteralSet
+	}
+	return nil, false
+}
+
+// cross returns every concatenation of a string from a with one from b, or
+// false if there would be too many.
+func cross(a, b []string) ([]string, bool) {
+	if len(a)*len(b) > maxLiteralSet {
+		return nil, false
+	}
+	var set []string
+	for _, x := range a {
+		for _, y := range b {
+			set = union(set, []string{x + y})
+		}
+	}
+	return set, true
+}
+
+func union(a, b []string) []string {
+	for _, s := range b {
+		found := false
+		for _, t := range a {
+			if s == t {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+// better returns whichever literal set has the longer shortest member.
+func better(a, b []string) []string {
+	if shortest(b) > shortest(a) {
+		return b
+	}
+	return a
+}
+
+// shortest returns the length of the shortest string in set, or 0 for an
+// empty set.
+func shortest(set []string) int {
+	if len(set) == 0 {
+		return 0
+	}
+	min := len(set[0])
+	for _, s := range set[1:] {
+		if len(s) < min {
+			min = len(s)
+		}
+	}
+	return min
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// proxyLog records the targets a proxy stand-in was asked to reach.
+type proxyLog struct {
+	mu      sync.Mutex
+	targets []string
+}
+
+func (l *proxyLog) add(target string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.targets = append(l.targets, target)
+}
+
+func (l *proxyLog) get() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.targets...)
+}
+
+// newHTTPProxy starts an HTTP proxy stand-in that forwards plain requests and
+// tunnels CONNECT requests.
+func newHTTPProxy(t *testing.T) (string, *proxyLog) {
+	log := &proxyLog{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.add(r.Host)
+		if r.Method != http.MethodConnect {
+			out := r.Clone(r.Context())
+			out.RequestURI = ""
+			resp, err := (&http.Transport{}).RoundTrip(out)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+			return
+		}
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			upstream.Close()
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		pipe(conn, upstream)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, log
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy stand-in that supports CONNECT
+// without authentication.
+func newSOCKS5Proxy(t *testing.T) (string, *proxyLog) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	log := &proxyLog{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				target, err := socks5Handshake(conn)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				log.add(target)
+				upstream, err := net.Dial("tcp", target)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				pipe(conn, upstream)
+			}()
+		}
+	}()
+	return "socks5://" + listener.Addr().String(), log
+}
+
+// socks5Handshake accepts a client without authentication and returns the
+// address it asks to connect to.
+func socks5Handshake(conn net.Conn) (string, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return "", err
+	}
+	conn.Write([]byte{5, 0})
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", err
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		addr := make([]byte, 4)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", err
+		}
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", io.ErrUnexpectedEOF
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// pipe copies between two connections until either side closes.
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() { io.Copy(a, b); done <- struct{}{} }()
+	go func() { io.Copy(b, a); done <- struct{}{} }()
+	<-done
+	a.Close()
+	b.Close()
+}
+
+// writeCABundle writes the test server's certificate to a PEM file.
+func writeCABundle(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func verifyWith(t *testing.T, settings config.HTTP, svc config.Service) Result {
+	client, err := NewClient(settings, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false)
+}
+
+func TestProxies(t *testing.T) {
+	tlsTarget, _ := newTestServer(t, false)
+	plainTarget := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer plainTarget.Close()
+	bundle := writeCABundle(t, tlsTarget)
+
+	httpProxy, httpLog := newHTTPProxy(t)
+	socksProxy, socksLog := newSOCKS5Proxy(t)
+
+	tests := []struct {
+		name   string
+		proxy  string
+		log    *proxyLog
+		target *httptest.Server
+	}{
+		{"http proxy, http target", httpProxy, httpLog, plainTarget},
+		{"http proxy, https target via CONNECT", httpProxy, httpLog, tlsTarget},
+		{"socks5 proxy, https target", socksProxy, socksLog, tlsTarget},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := len(test.log.get())
+			result := verifyWith(t, config.HTTP{Proxy: test.proxy, CABundle: bundle}, testService(test.target.URL))
+			if !result.Valid() {
+				t.Fatalf("expected valid through the proxy, got %s: %s", result.Status, result.Reason)
+			}
+			targets := test.log.get()[before:]
+			if len(targets) == 0 || targets[0] != test.target.Listener.Addr().String() {
+				t.Errorf("proxy saw targets %v, want %s", targets, test.target.Listener.Addr())
+			}
+		})
+	}
+}
+
+func TestServiceProxyOverridesGlobal(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer target.Close()
+	global, globalLog := newHTTPProxy(t)
+	own, ownLog := newHTTPProxy(t)
+
+	svc := testService(target.URL)
+	svc.Proxy = own
+	if result := verifyWith(t, config.HTTP{Proxy: global}, svc); !result.Valid() {
+		t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+	}
+	if len(ownLog.get()) != 1 || len(globalLog.get()) != 0 {
+		t.Errorf("service proxy saw %v and global proxy %v, want only the service proxy used", ownLog.get(), globalLog.get())
+	}
+
+	svc.Proxy = "direct"
+	if result := verifyWith(t, config.HTTP{Proxy: global}, svc); !result.Valid() {
+		t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+	}
+	if len(globalLog.get()) != 0 {
+		t.Errorf("global proxy saw %v for a service connecting directly", globalLog.get())
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer target.Close()
+	proxy, log := newHTTPProxy(t)
+	t.Setenv("MANTRAMATCH_PROXY", proxy)
+
+	if result := verifyWith(t, config.HTTP{}, testService(target.URL)); !result.Valid() {
+		t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+	}
+	if len(log.get()) != 1 {
+		t.Errorf("proxy from MANTRAMATCH_PROXY saw %v, want one request", log.get())
+	}
+}
+
+func TestCABundle(t *testing.T) {
+	target, _ := newTestServer(t, false)
+	svc := testService(target.URL)
+
+	if result := verifyWith(t, config.HTTP{}, svc); result.Status != StatusError {
+		t.Errorf("expected an untrusted certificate to be an error, got %s: %s", result.Status, result.Reason)
+	}
+	if result := verifyWith(t, config.HTTP{CABundle: writeCABundle(t, target)}, svc); !result.Valid() {
+		t.Errorf("expected the CA bundle to be trusted, got %s: %s", result.Status, result.Reason)
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// Weights of the parts of a candidate's confidence score.
+const (
+	specificityWeight = 0.5
+	entropyWeight     = 0.3
+	contextWeight     = 0.2
+)
+
+// Candidate is a service whose regex matched a key, with a confidence in
+// [0, 1] that the key belongs to it. ContextMatch reports whether one of the
+// service's context keywords appeared around the key. Generic reports that
+// only a pattern without a distinctive literal, such as 32 hex characters,
+// points to the service: no context keyword, parameter or extra credential
+// part singles it out from the other services sharing that pattern.
+type Candidate struct {
+	Service      config.Service
+	Confidence   float64
+	ContextMatch bool
+	Generic      bool
+}
+
+// Rank returns the services matching cred's key, most confident first.
+// Services with a distinctive literal such as "sk_live_" rank above generic
+// patterns like 32 hex characters. When the key was found next to one of a
+// service's context keywords, those services rank first and the others
+// lose their context score. Ties keep configuration order.
+func (m *Matcher) Rank(cred Credential) []Candidate {
+	key := cred.Key()
+	entropy := math.Min(shannonEntropy(key)/4, 1)
+
+	var candidates []Candidate
+	var contexts []float64
+	contextual := false
+	for _, i := range m.match(key) {
+		context, matched := contextScore(m.services[i], cred)
+		contextual = contextual || matched
+		specificity := math.Min(float64(m.specificity[i])/8, 1)
+		candidates = append(candidates, Candidate{
+			Service:      m.services[i],
+			Confidence:   specificityWeight*specificity + entropyWeight*entropy,
+			ContextMatch: matched,
+			// A full context score means parameters or credential parts
+			// fit the service.
+			Generic: m.specificity[i] == 0 && !matched && context < 1,
+		})
+		contexts = append(contexts, context)
+	}
+
+	for i := range candidates {
+		if !contextual || candidates[i].ContextMatch {
+			candidates[i].Confidence += contextWeight * contexts[i]
+		}
+		candidates[i].Confidence = math.Round(candidates[i].Confidence*100) / 100
+	}
+
+	sort.SliceStable(candidates, func(a, b int) bool {
+		if candidates[a].ContextMatch != candidates[b].ContextMatch {
+			return candidates[a].ContextMatch
+		}
+		return candidates[a].Confidence > candidates[b].Confidence
+	})
+	return candidates
+}
+
+// contextScore rates how well the rest of the input fits the service: the
+// number of credential parts supplied, any parameters given for it, and its
+// context keywords appearing in the key's name or nearby text. matched
+// reports a context keyword match.
+func contextScore(service config.Service, cred Credential) (score float64, matched bool) {
+	if containsKeyword(cred.KeyNames, service.Context) {
+		return 1, true
+	}
+	if containsKeyword(cred.Nearby, service.Context) {
+		return 0.8, true
+	}
+	parts := len(cred.Parts)
+	if parts < service.RequiredCredentials() || parts > len(service.CredentialNames()) {
+		return 0, false
+	}
+	for _, param := range service.Parameters {
+		if _, ok := cred.Params[param.Name]; ok {
+			return 1, false
+		}
+	}
+	if parts > 1 {
+		return 1, false
+	}
+	return 0.5, false
+}
+
+// containsKeyword reports whether any keyword occurs in any of texts,
+// ignoring case.
+func containsKeyword(texts, keywords []string) bool {
+	for _, text := range texts {
+		text = strings.ToLower(text)
+		for _, keyword := range keywords {
+			if strings.Contains(text, strings.ToLower(keyword)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shannonEntropy returns the entropy of s in bits per character. Random
+// hex scores close to 4; placeholders such as "xxxx" score close to 0.
+func shannonEntropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	entropy := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+func TestRankMarksGenericCandidates(t *testing.T) {
+	var services []config.Service
+	for _, svc := range []config.Service{
+		{Name: "Hex", Regex: `^[a-f0-9]{32}$`},
+		{Name: "Hex with context", Regex: `^[a-f0-9]{32}$`, Context: []string{"bravo"}},
+		{Name: "Hex with parameter", Regex: `^[a-f0-9]{32}$`, Parameters: []config.Parameter{{Name: "account"}}},
+		{Name: "Hex pair", Regex: `^[a-f0-9]{32}$`, Credentials: []string{"key", "secret"}},
+		{Name: "Prefixed", Regex: `^[a-f0-9]{4}beef[a-f0-9]{24}$`},
+	} {
+		if err := svc.Compile(); err != nil {
+			t.Fatal(err)
+		}
+		services = append(services, svc)
+	}
+	matcher, err := NewMatcher(services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const key = "9f86beef884c7d659a2feaa0c55ad015"
+
+	tests := []struct {
+		name    string
+		cred    Credential
+		generic []string
+	}{
+		{"bare key", Credential{Parts: []string{key}}, []string{"Hex", "Hex with context", "Hex with parameter", "Hex pair"}},
+		{"context keyword", Credential{Parts: []string{key}, KeyNames: []string{"BRAVO_KEY"}}, []string{"Hex", "Hex with parameter", "Hex pair"}},
+		{"parameter given", Credential{Parts: []string{key}, Params: map[string]string{"account": "acme"}}, []string{"Hex", "Hex with context", "Hex pair"}},
+		{"parts fit", Credential{Parts: []string{key, "secret"}}, []string{"Hex", "Hex with context", "Hex with parameter"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, candidate := range matcher.Rank(tt.cred) {
+				if candidate.Generic {
+					got = append(got, candidate.Service.Name)
+				}
+			}
+			if !sameSet(got, tt.generic) {
+				t.Errorf("generic candidates = %v, want %v", got, tt.generic)
+			}
+		})
+	}
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool)
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if !seen[s] {
+			return false
+		}
+	}
+	return true
+}
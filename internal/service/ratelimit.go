@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// limiter keeps a token bucket per host, so every request to a host counts
+// against the same limit whichever key or service it is for.
+type limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket holds up to burst tokens, refilled one per interval. tokens goes
+// negative as requests queue up.
+type bucket struct {
+	interval time.Duration
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+func newLimiter() *limiter {
+	return &limiter{buckets: make(map[string]*bucket)}
+}
+
+// wait blocks until a request to host is allowed at rate, or ctx is done.
+func (l *limiter) wait(ctx context.Context, host string, rate config.Rate) error {
+	return sleep(ctx, l.reserve(host, rate, time.Now()))
+}
+
+// reserve takes a token from host's bucket and returns how long to wait
+// before using it. Services sharing a host but declaring different rates get
+// the slowest of them.
+func (l *limiter) reserve(host string, rate config.Rate, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	interval := rate.Per / time.Duration(rate.Requests)
+	burst := float64(rate.Requests)
+	b := l.buckets[host]
+	if b == nil {
+		b = &bucket{interval: interval, burst: burst, tokens: burst, last: now}
+		l.buckets[host] = b
+	}
+	if interval > b.interval {
+		b.interval = interval
+	}
+	if burst < b.burst {
+		b.burst = burst
+	}
+
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
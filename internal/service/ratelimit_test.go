@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	l := newLimiter()
+	start := time.Now()
+	rate := config.Rate{Requests: 2, Per: time.Second}
+
+	var delays []time.Duration
+	for i := 0; i < 4; i++ {
+		delays = append(delays, l.reserve("api.example.com", rate, start))
+	}
+	want := []time.Duration{0, 0, 500 * time.Millisecond, time.Second}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("request %d waits %v, want %v", i+1, delays[i], want[i])
+		}
+	}
+
+	if delay := l.reserve("other.example.com", rate, start); delay != 0 {
+		t.Errorf("another host waits %v, want no wait", delay)
+	}
+
+	// A second later the queued requests have gone and the bucket is empty.
+	if delay := l.reserve("api.example.com", rate, start.Add(time.Second)); delay != 500*time.Millisecond {
+		t.Errorf("request after the queue drained waits %v, want 500ms", delay)
+	}
+}
+
+func TestLimiterUsesSlowestRateForHost(t *testing.T) {
+	l := newLimiter()
+	start := time.Now()
+	l.reserve("api.example.com", config.Rate{Requests: 10, Per: time.Second}, start)
+	if delay := l.reserve("api.example.com", config.Rate{Requests: 1, Per: time.Second}, start); delay != 0 {
+		t.Fatalf("first request at the slower rate waits %v, want no wait", delay)
+	}
+	if delay := l.reserve("api.example.com", config.Rate{Requests: 10, Per: time.Second}, start); delay < time.Second {
+		t.Errorf("request at the faster rate waits %v, want the slower rate's interval", delay)
+	}
+}
+
+func TestServiceRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	svc := testService(server.URL)
+	svc.RateLimit = "2/100ms"
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false); !result.Valid() {
+			t.Fatalf("expected valid, got %s: %s", result.Status, result.Reason)
+		}
+	}
+	// Two requests go at once, then one every 50ms.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("6 requests at 2/100ms took %v, want at least 200ms", elapsed)
+	}
+
+	// The CLI override lifts the service's limit.
+	client, err = NewClient(config.HTTP{RateLimit: "none"}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	for i := 0; i < 6; i++ {
+		VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("6 requests without a limit took %v", elapsed)
+	}
+}
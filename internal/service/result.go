@@ -0,0 +1,90 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// Status is the outcome of verifying a key against a service.
+type Status string
+
+const (
+	// StatusValid means the service accepted the key.
+	StatusValid Status = "valid"
+	// StatusInvalid means the service answered and rejected the key.
+	StatusInvalid Status = "invalid"
+	// StatusRateLimited means the service asked us to slow down (HTTP 429)
+	// before giving an answer.
+	StatusRateLimited Status = "rate_limited"
+	// StatusError means the verification couldn't be completed: a network
+	// failure, timeout or server error.
+	StatusError Status = "error"
+	// StatusUnverifiable means the key can't be checked as supplied, for
+	// example because a credential part or service parameter is missing.
+	StatusUnverifiable Status = "unverifiable"
+	// StatusUnknown means the service declares invalid indicators but the
+	// response matched neither those nor the success indicator.
+	StatusUnknown Status = "unknown"
+	// StatusSkipped means the key wasn't verified against the service,
+	// because other services matched it with higher confidence or the run
+	// was stopped before the verification finished.
+	StatusSkipped Status = "skipped"
+)
+
+// Result describes the outcome of a single verification.
+type Result struct {
+	Service    string
+	Status     Status
+	Reason     string
+	HTTPStatus int
+	Latency    time.Duration
+	// Confidence is how likely the key is to belong to the service, judged
+	// from the match alone (see Matcher.Rank).
+	Confidence float64
+	// Details holds identity information reported by the service for a
+	// valid key, such as the AWS caller ARN or fields named in the
+	// service's extract section.
+	Details map[string]string
+	// Severity rates a valid key from the service's catalog entry and the
+	// capabilities it was found to grant.
+	Severity     string
+	Capabilities []CapabilityResult
+	// MissingParameters lists the service parameters the user still has to
+	// supply when Status is StatusUnverifiable.
+	MissingParameters []config.Parameter
+	// Retries lists the attempts that failed transiently and were retried,
+	// whatever the final outcome.
+	Retries []Retry
+}
+
+// Valid reports whether the key was accepted.
+func (r Result) Valid() bool {
+	return r.Status == StatusValid
+}
+
+// statusForCode classifies an HTTP status that didn't satisfy the service's
+// validation.
+func statusForCode(code int) Status {
+	switch {
+	case code == http.StatusTooManyRequests:
+		return StatusRateLimited
+	case code >= 500:
+		return StatusError
+	default:
+		return StatusInvalid
+	}
+}
+
+// statusCodeError is returned for an HTTP response outside a request's
+// expected range, so the caller can classify it.
+type statusCodeError struct {
+	what string
+	code int
+}
+
+func (e *statusCodeError) Error() string {
+	return fmt.Sprintf("%s returned status code: %d", e.what, e.code)
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// defaultRetry applies where neither the configuration nor the service sets
+// a retry option.
+var defaultRetry = config.Retry{
+	MaxAttempts: 3,
+	Backoff:     time.Second,
+	MaxBackoff:  30 * time.Second,
+}
+
+// Retry records a request attempt that failed transiently and was retried.
+type Retry struct {
+	Host   string
+	Reason string
+	// Wait is how long the next attempt was delayed.
+	Wait time.Duration
+}
+
+// retryLog collects the retries made during one verification.
+type retryLog struct {
+	mu      sync.Mutex
+	retries []Retry
+}
+
+// add records a retry. It does nothing on a nil log, for requests made
+// outside a verification.
+func (l *retryLog) add(retry Retry) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.retries = append(l.retries, retry)
+}
+
+func (l *retryLog) get() []Retry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Retry(nil), l.retries...)
+}
+
+// retryReason says why an attempt is worth retrying, or returns "" if its
+// outcome is final.
+func retryReason(resp *http.Response, err error) string {
+	if err != nil {
+		if transientError(err) {
+			return err.Error()
+		}
+		return ""
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Sprintf("status code %d", resp.StatusCode)
+	}
+	return ""
+}
+
+// transientError reports whether a request error may not recur: a timeout or
+// a dropped connection, rather than, say, an unknown host or a bad
+// certificate.
+func transientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryDelay returns how long to wait before the attempt after attempt. A
+// Retry-After header is honoured; if it asks for longer than MaxBackoff, ok
+// is false and the failure stands. Otherwise the delay doubles with each
+// attempt, with jitter so that concurrent verifications don't retry in step.
+func retryDelay(policy config.Retry, attempt int, resp *http.Response) (delay time.Duration, ok bool) {
+	if resp != nil {
+		if wait, found := parseRetryAfter(resp.Header.Get("Retry-After")); found {
+			return wait, wait <= policy.MaxBackoff
+		}
+	}
+	delay = policy.Backoff
+	for i := 1; i < attempt && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	// Wait between half and all of the backoff.
+	half := delay / 2
+	if half > 0 {
+		delay = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	return delay, true
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// rewind returns a copy of req to send again, with its body restored.
+func rewind(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return next, nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body can't be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	next.Body = body
+	return next, nil
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// fastRetry keeps the backoff short enough for tests.
+var fastRetry = config.Retry{Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond}
+
+// flakyServer fails the first failures requests with fail, then accepts.
+func flakyServer(t *testing.T, failures int64, fail func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&requests, 1) <= failures {
+			fail(w, r)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func verifyRetrying(t *testing.T, settings config.HTTP, svc config.Service) Result {
+	if svc.Retry == nil {
+		svc.Retry = &fastRetry
+	}
+	return verifyWith(t, settings, svc)
+}
+
+func TestRetryTransientStatus(t *testing.T) {
+	server := flakyServer(t, 2, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	result := verifyRetrying(t, config.HTTP{}, testService(server.URL))
+	if !result.Valid() {
+		t.Fatalf("expected valid on the third attempt, got %s: %s", result.Status, result.Reason)
+	}
+	if len(result.Retries) != 2 || result.Retries[0].Reason != "status code 503" {
+		t.Errorf("unexpected retries: %+v", result.Retries)
+	}
+}
+
+func TestRetryGivesUpAfterMaxAttempts(t *testing.T) {
+	server := flakyServer(t, 10, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+
+	// The service's own setting overrides the global one.
+	svc := testService(server.URL)
+	retry := fastRetry
+	retry.MaxAttempts = 2
+	svc.Retry = &retry
+	result := verifyRetrying(t, config.HTTP{Retry: config.Retry{MaxAttempts: 5}}, svc)
+	if result.Status != StatusError || result.HTTPStatus != http.StatusBadGateway {
+		t.Errorf("expected error with status 502, got %s (%d)", result.Status, result.HTTPStatus)
+	}
+	if len(result.Retries) != 1 {
+		t.Errorf("expected 1 retry, got %+v", result.Retries)
+	}
+}
+
+func TestRetryNotForFinalAnswers(t *testing.T) {
+	server := flakyServer(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	result := verifyRetrying(t, config.HTTP{}, testService(server.URL))
+	if result.Status != StatusInvalid || len(result.Retries) != 0 {
+		t.Errorf("expected invalid without retries, got %s after %d retries", result.Status, len(result.Retries))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	server := flakyServer(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	svc := testService(server.URL)
+	retry := fastRetry
+	retry.MaxBackoff = 2 * time.Second
+	svc.Retry = &retry
+	start := time.Now()
+	result := verifyRetrying(t, config.HTTP{}, svc)
+	if !result.Valid() {
+		t.Fatalf("expected valid after waiting, got %s: %s", result.Status, result.Reason)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %v, want the requested 1s", elapsed)
+	}
+	if len(result.Retries) != 1 || result.Retries[0].Wait != time.Second {
+		t.Errorf("unexpected retries: %+v", result.Retries)
+	}
+}
+
+func TestRetryAfterBeyondMaxBackoff(t *testing.T) {
+	server := flakyServer(t, 1, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+
+	result := verifyRetrying(t, config.HTTP{}, testService(server.URL))
+	if result.Status != StatusRateLimited || len(result.Retries) != 0 {
+		t.Errorf("expected rate_limited without waiting, got %s after %d retries", result.Status, len(result.Retries))
+	}
+}
+
+func TestRetryDroppedConnectionReplaysBody(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(bodies) == 1 {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	svc := testService(server.URL)
+	svc.VerifyMethod = "POST"
+	svc.Body = map[interface{}]interface{}{"token": "{{.key}}"}
+	svc.BodyType = "json"
+
+	result := verifyRetrying(t, config.HTTP{}, svc)
+	if !result.Valid() {
+		t.Fatalf("expected valid after the dropped connection, got %s: %s", result.Status, result.Reason)
+	}
+	if len(result.Retries) != 1 || len(bodies) != 2 || bodies[1] != bodies[0] || !strings.Contains(bodies[1], `"key"`) {
+		t.Errorf("expected the body to be sent again, got retries %+v and bodies %q", result.Retries, bodies)
+	}
+}
@@ -1,122 +1,359 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"regexp"
+	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/harshinsecurity/mantramatch/internal/config"
+	"github.com/harshinsecurity/mantramatch/internal/jsonpath"
 )
 
-func MatchServices(services []config.Service, apiKey string) []config.Service {
-	var matches []config.Service
-	for _, service := range services {
-		regex := regexp.MustCompile(service.Regex)
-		if regex.MatchString(apiKey) {
-			matches = append(matches, service)
+// VerifyKey checks cred against service and reports the outcome, including
+// identity details for valid keys where the service provides them. Requests
+// are sent with client, normally one from NewClient shared by every call, and
+// any that were retried are listed in the result. Cancelling ctx aborts the
+// requests in flight, and the verification ends with StatusError.
+func VerifyKey(ctx context.Context, client *http.Client, service config.Service, cred Credential, verbose bool) Result {
+	start := time.Now()
+	retries := &retryLog{}
+	var result Result
+	if client, err := clientFor(client, service, retries); err != nil {
+		result = errorResult(err)
+	} else {
+		result = verifyKey(ctx, client, service, cred, verbose)
+	}
+	result.Service = service.Name
+	result.Retries = retries.get()
+	result.Latency = time.Since(start)
+	if !result.Valid() {
+		logError(fmt.Sprintf("%s: %s: %s", service.Name, result.Status, result.Reason), verbose)
+	}
+	return result
+}
+
+func verifyKey(ctx context.Context, client *http.Client, service config.Service, cred Credential, verbose bool) Result {
+	if missing := MissingParameters(service, cred); len(missing) > 0 {
+		var names []string
+		for _, param := range missing {
+			names = append(names, param.Name)
+		}
+		return Result{
+			Status:            StatusUnverifiable,
+			Reason:            "needs parameter " + strings.Join(names, ", "),
+			MissingParameters: missing,
+		}
+	}
+
+	values, err := cred.values(service)
+	if err != nil {
+		return Result{Status: StatusUnverifiable, Reason: err.Error()}
+	}
+
+	for i, step := range service.Steps {
+		stepName := step.Name
+		if stepName == "" {
+			stepName = fmt.Sprintf("step %d", i+1)
+		}
+
+		resp, body, err := doStep(ctx, client, step, cred, values)
+		if err != nil {
+			return errorResult(fmt.Errorf("%s: %w", stepName, err))
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return Result{
+				Status:     statusForCode(resp.StatusCode),
+				Reason:     fmt.Sprintf("%s returned status code: %d", stepName, resp.StatusCode),
+				HTTPStatus: resp.StatusCode,
+			}
+		}
+
+		extracted, err := extractValues(step.Extract, resp.Header, body)
+		if err != nil {
+			return Result{
+				Status:     StatusInvalid,
+				Reason:     fmt.Sprintf("%s: %v", stepName, err),
+				HTTPStatus: resp.StatusCode,
+			}
+		}
+		for name, value := range extracted {
+			values[name] = value
+		}
+	}
+
+	resp, body, err := doStep(ctx, client, service.VerifyStep(), cred, values)
+	if err != nil {
+		return errorResult(err)
+	}
+
+	result := Result{HTTPStatus: resp.StatusCode}
+	if invalid, reason := isInvalidResponse(service, resp.StatusCode, resp.Header, body, verbose); invalid {
+		result.Status = StatusInvalid
+		result.Reason = reason
+		return result
+	}
+	if valid, reason := isValidResponse(service, resp.StatusCode, resp.Header, body, verbose); !valid {
+		switch {
+		case service.Validation.StatusCode != 0 && resp.StatusCode != service.Validation.StatusCode &&
+			statusForCode(resp.StatusCode) != StatusInvalid:
+			result.Status = statusForCode(resp.StatusCode)
+		case len(service.Validation.InvalidIndicators) > 0:
+			result.Status = StatusUnknown
+			reason = "unrecognised response: " + reason
+		default:
+			result.Status = StatusInvalid
 		}
+		result.Reason = reason
+		return result
 	}
-	return matches
+
+	result.Status = StatusValid
+	result.Details = authDetails(service.Auth, body)
+	if metadata := extractMetadata(service.Extract, resp.Header, body, verbose); len(metadata) > 0 {
+		if result.Details == nil {
+			result.Details = make(map[string]string, len(metadata))
+		}
+		for name, value := range metadata {
+			result.Details[name] = value
+		}
+	}
+	result.Capabilities, result.Severity = probeCapabilities(ctx, client, service, cred, values, verbose)
+	return result
 }
 
-func VerifyKey(service config.Service, apiKey string, timeout int, verbose bool) bool {
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+// errorResult classifies an error from making a request. Errors carrying an
+// HTTP status, such as a rejected token exchange, are judged by that status.
+func errorResult(err error) Result {
+	var statusErr *statusCodeError
+	if errors.As(err, &statusErr) {
+		return Result{Status: statusForCode(statusErr.code), Reason: err.Error(), HTTPStatus: statusErr.code}
+	}
+	return Result{Status: StatusError, Reason: err.Error()}
+}
 
-	req, err := createRequest(service, apiKey)
+// doStep sends a single request of a verification flow and reads its body.
+func doStep(ctx context.Context, client *http.Client, step config.Step, cred Credential, values map[string]string) (*http.Response, []byte, error) {
+	req, err := createRequest(ctx, client, step, cred, values)
 	if err != nil {
-		logError(fmt.Sprintf("Error creating request for %s: %v", service.Name, err), verbose)
-		return false
+		return nil, nil, fmt.Errorf("error creating request: %w", err)
 	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		logError(fmt.Sprintf("Error making request to %s: %v", service.Name, err), verbose)
-		return false
+		return nil, nil, fmt.Errorf("error making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		logError(fmt.Sprintf("Error reading response from %s: %v", service.Name, err), verbose)
-		return false
+		return nil, nil, fmt.Errorf("error reading response: %w", err)
 	}
-
-	return isValidResponse(service, resp.StatusCode, resp.Header, body, verbose)
+	return resp, body, nil
 }
 
-func createRequest(service config.Service, apiKey string) (*http.Request, error) {
-	url := strings.ReplaceAll(service.VerifyURL, "%s", apiKey)
-	req, err := http.NewRequest(service.VerifyMethod, url, nil)
+func createRequest(ctx context.Context, client *http.Client, step config.Step, cred Credential, values map[string]string) (*http.Request, error) {
+	url, err := render(step.URL, cred, values)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, contentType, err := renderBody(step.Body, step.BodyType, cred, values)
 	if err != nil {
 		return nil, err
 	}
 
-	for key, value := range service.Headers {
-		req.Header.Add(key, strings.ReplaceAll(value, "%s", apiKey))
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, step.Method, url, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for key, value := range step.Headers {
+		rendered, err := render(value, cred, values)
+		if err != nil {
+			return nil, err
+		}
+		if http.CanonicalHeaderKey(key) == "Content-Type" {
+			req.Header.Set(key, rendered)
+		} else {
+			req.Header.Add(key, rendered)
+		}
+	}
+
+	if err := applyAuth(client, req, step.Auth, cred, values, payload); err != nil {
+		return nil, err
 	}
 
 	return req, nil
 }
 
-func isValidResponse(service config.Service, statusCode int, headers http.Header, body []byte, verbose bool) bool {
-	if statusCode != service.Validation.StatusCode {
-		logError(fmt.Sprintf("%s returned unexpected status code: %d", service.Name, statusCode), verbose)
-		return false
+// isValidResponse checks a verification response against the service's
+// validation rules. When the response fails them, it also says why.
+func isValidResponse(service config.Service, statusCode int, headers http.Header, body []byte, verbose bool) (bool, string) {
+	if service.Validation.StatusCode != 0 && statusCode != service.Validation.StatusCode {
+		return false, fmt.Sprintf("unexpected status code: %d", statusCode)
 	}
 
 	if service.Validation.ContentType != "" && !strings.HasPrefix(headers.Get("Content-Type"), service.Validation.ContentType) {
-		logError(fmt.Sprintf("%s returned unexpected content type: %s", service.Name, headers.Get("Content-Type")), verbose)
-		return false
+		return false, fmt.Sprintf("unexpected content type: %s", headers.Get("Content-Type"))
+	}
+
+	if !evaluateIndicator(service, service.Validation.SuccessIndicator, statusCode, headers, body, verbose) {
+		return false, "response did not match success indicator"
+	}
+	return true, ""
+}
+
+// isInvalidResponse reports whether the response matches one of the
+// service's invalid indicators, meaning the key is definitely revoked or bad.
+func isInvalidResponse(service config.Service, statusCode int, headers http.Header, body []byte, verbose bool) (bool, string) {
+	for _, indicator := range service.Validation.InvalidIndicators {
+		if evaluateIndicator(service, indicator, statusCode, headers, body, verbose) {
+			return true, "matched invalid indicator: " + describeIndicator(indicator)
+		}
 	}
+	return false, ""
+}
+
+// describeIndicator summarises an indicator for use in a result reason.
+func describeIndicator(indicator config.SuccessIndicator) string {
+	switch {
+	case indicator.Condition != nil:
+		return indicator.Type + " (" + describeIndicator(*indicator.Condition) + ")"
+	case len(indicator.Conditions) > 0:
+		parts := make([]string, len(indicator.Conditions))
+		for i, condition := range indicator.Conditions {
+			parts[i] = describeIndicator(condition)
+		}
+		return indicator.Type + " (" + strings.Join(parts, ", ") + ")"
+	case indicator.Key != "" && indicator.Value != "":
+		return fmt.Sprintf("%s %s=%q", indicator.Type, indicator.Key, indicator.Value)
+	case indicator.Key != "":
+		return fmt.Sprintf("%s %s", indicator.Type, indicator.Key)
+	case indicator.Value != "":
+		return fmt.Sprintf("%s %q", indicator.Type, indicator.Value)
+	default:
+		return indicator.Type
+	}
+}
 
-	switch service.Validation.SuccessIndicator.Type {
+// evaluateIndicator checks a single success indicator against the response,
+// recursing into all_of, any_of and not.
+func evaluateIndicator(service config.Service, indicator config.SuccessIndicator, statusCode int, headers http.Header, body []byte, verbose bool) bool {
+	switch indicator.Type {
+	case "all_of":
+		for _, condition := range indicator.Conditions {
+			if !evaluateIndicator(service, condition, statusCode, headers, body, verbose) {
+				return false
+			}
+		}
+		return true
+	case "any_of":
+		for _, condition := range indicator.Conditions {
+			if evaluateIndicator(service, condition, statusCode, headers, body, verbose) {
+				return true
+			}
+		}
+		return false
+	case "not":
+		return !evaluateIndicator(service, *indicator.Condition, statusCode, headers, body, verbose)
+	case "status_code":
+		return strconv.Itoa(statusCode) == indicator.Value
 	case "status_code_only":
 		return true
 	case "json_key_exists", "json_key_value":
-		return validateJSONResponse(service, body, verbose)
+		return validateJSONResponse(service, indicator, body, verbose)
 	case "contains_string":
-		return strings.Contains(string(body), service.Validation.SuccessIndicator.Value)
+		return strings.Contains(string(body), indicator.Value)
 	case "regex_match":
-		regex := regexp.MustCompile(service.Validation.SuccessIndicator.Value)
+		regex, err := indicator.Regexp()
+		if err != nil {
+			logError(fmt.Sprintf("Invalid regex for %s: %v", service.Name, err), verbose)
+			return false
+		}
 		return regex.Match(body)
 	case "header_exists", "header_value":
-		return validateHeaderResponse(service, headers, verbose)
+		return validateHeaderResponse(indicator, headers)
 	default:
-		logError(fmt.Sprintf("Unknown validation type for %s: %s", service.Name, service.Validation.SuccessIndicator.Type), verbose)
+		logError(fmt.Sprintf("Unknown validation type for %s: %s", service.Name, indicator.Type), verbose)
 		return false
 	}
 }
 
-func validateJSONResponse(service config.Service, body []byte, verbose bool) bool {
-	var result map[string]interface{}
+func validateJSONResponse(service config.Service, indicator config.SuccessIndicator, body []byte, verbose bool) bool {
+	var result interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
 		logError(fmt.Sprintf("Error parsing JSON response from %s: %v", service.Name, err), verbose)
 		return false
 	}
 
-	value, exists := result[service.Validation.SuccessIndicator.Key]
-	if !exists {
+	path, err := jsonpath.Compile(indicator.Key)
+	if err != nil {
+		logError(fmt.Sprintf("Invalid JSON path for %s: %v", service.Name, err), verbose)
 		return false
 	}
 
-	if service.Validation.SuccessIndicator.Type == "json_key_value" {
-		return fmt.Sprintf("%v", value) == service.Validation.SuccessIndicator.Value
+	matches := path.Find(result)
+	if indicator.Type == "json_key_exists" {
+		return len(matches) > 0
 	}
 
-	return true
+	// With wildcards, any selected value may satisfy the indicator.
+	for _, value := range matches {
+		if jsonValueEquals(value, indicator.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonValueEquals compares a decoded JSON value with an expected value from
+// the configuration, interpreting expected according to the value's JSON
+// type: "true" matches the boolean true as well as the string "true", while
+// "True" and "1" don't match the boolean; "1.0" matches the number 1 but not
+// the string "1".
+func jsonValueEquals(value interface{}, expected string) bool {
+	switch v := value.(type) {
+	case string:
+		return v == expected
+	case bool:
+		return expected == strconv.FormatBool(v)
+	case float64:
+		f, err := strconv.ParseFloat(expected, 64)
+		return err == nil && f == v
+	case nil:
+		return expected == "null"
+	default:
+		var want interface{}
+		if err := json.Unmarshal([]byte(expected), &want); err != nil {
+			return false
+		}
+		return reflect.DeepEqual(value, want)
+	}
 }
 
-func validateHeaderResponse(service config.Service, headers http.Header, verbose bool) bool {
-	value := headers.Get(service.Validation.SuccessIndicator.Key)
-	if service.Validation.SuccessIndicator.Type == "header_exists" {
+func validateHeaderResponse(indicator config.SuccessIndicator, headers http.Header) bool {
+	value := headers.Get(indicator.Key)
+	if indicator.Type == "header_exists" {
 		return value != ""
 	}
-	return value == service.Validation.SuccessIndicator.Value
+	return value == indicator.Value
 }
 
 func logError(message string, verbose bool) {
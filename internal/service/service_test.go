@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestJSONValueEquals(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected string
+		want     bool
+	}{
+		{`true`, "true", true},
+		{`false`, "false", true},
+		{`true`, "false", false},
+		{`true`, "1", false},
+		{`true`, "True", false},
+		{`"true"`, "true", true},
+		{`"true"`, "True", false},
+		{`1`, "1", true},
+		{`1`, "1.0", true},
+		{`1000`, "1e3", true},
+		{`1.5`, "1.50", true},
+		{`1`, "one", false},
+		{`"1"`, "1", true},
+		{`"1"`, "1.0", false},
+		{`null`, "null", true},
+		{`null`, "", false},
+		{`"null"`, "null", true},
+		{`""`, "", true},
+		{`[1, "a"]`, `[1, "a"]`, true},
+		{`{"a": 1}`, `{"a": 1.0}`, true},
+		{`{"a": 1}`, `{"a": 2}`, false},
+	}
+	for _, tt := range tests {
+		var value interface{}
+		if err := json.Unmarshal([]byte(tt.json), &value); err != nil {
+			t.Fatal(err)
+		}
+		if got := jsonValueEquals(value, tt.expected); got != tt.want {
+			t.Errorf("jsonValueEquals(%s, %q) = %v, want %v", tt.json, tt.expected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// Defaults for the settings in config.HTTP. Verifications run ten at a time
+// by default, so keeping as many idle connections per host lets a large list
+// reuse them.
+const (
+	defaultMaxConnsPerHost     = 10
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSSessionCacheSize = 256
+)
+
+// NewClient returns a client for verification requests, whose connection pool
+// and rate limits are meant to be shared by every verification so connections
+// to the same provider are kept alive and reused. Requests that fail
+// transiently are retried. timeout limits each attempt, in seconds, not
+// counting time spent waiting for the rate limit or between attempts.
+func NewClient(settings config.HTTP, timeout int) (*http.Client, error) {
+	transport, err := newTransport(settings)
+	if err != nil {
+		return nil, err
+	}
+	polite := &politeTransport{
+		base:    transport,
+		limiter: newLimiter(),
+		retry:   defaultRetry.Merge(settings.Retry),
+		timeout: time.Duration(timeout) * time.Second,
+	}
+	if settings.RateLimit != "" {
+		rate, err := config.ParseRate(settings.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		polite.override = &rate
+	}
+	return &http.Client{Transport: polite}, nil
+}
+
+func newTransport(settings config.HTTP) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy, err := globalProxy(settings.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if options, ok := req.Context().Value(serviceKey{}).(serviceOptions); ok && options.hasProxy {
+			return options.proxy, nil
+		}
+		return proxy(req)
+	}
+
+	transport.MaxConnsPerHost = orDefault(settings.MaxConnsPerHost, defaultMaxConnsPerHost)
+	transport.MaxIdleConnsPerHost = orDefault(settings.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	transport.MaxIdleConns = 0 // limited per host instead
+	transport.IdleConnTimeout = defaultIdleConnTimeout
+	if settings.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(settings.IdleConnTimeout) * time.Second
+	}
+	transport.DisableKeepAlives = settings.DisableKeepAlives
+
+	transport.TLSClientConfig = &tls.Config{
+		ClientSessionCache: tls.NewLRUClientSessionCache(orDefault(settings.TLSSessionCacheSize, defaultTLSSessionCacheSize)),
+	}
+	if settings.CABundle != "" {
+		roots, err := loadCABundle(settings.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+	// A custom TLS config turns off HTTP/2 unless it is asked for, and an
+	// empty TLSNextProto keeps it off.
+	transport.ForceAttemptHTTP2 = !settings.DisableHTTP2
+	if settings.DisableHTTP2 {
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport, nil
+}
+
+// globalProxy returns the proxy function for requests of services without
+// a proxy of their own: the configured proxy, else MANTRAMATCH_PROXY, else
+// the standard HTTPS_PROXY, HTTP_PROXY and NO_PROXY variables.
+func globalProxy(setting string) (func(*http.Request) (*url.URL, error), error) {
+	if setting == "" {
+		setting = os.Getenv("MANTRAMATCH_PROXY")
+	}
+	if setting == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxy, err := config.ParseProxy(setting)
+	if err != nil {
+		return nil, err
+	}
+	return http.ProxyURL(proxy), nil
+}
+
+// loadCABundle returns the system roots plus the certificates in the PEM
+// file at path.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return roots, nil
+}
+
+// serviceKey is the request context key for the serviceOptions of the
+// service a request is made for.
+type serviceKey struct{}
+
+// serviceOptions are a service's overrides of the client's settings.
+type serviceOptions struct {
+	// proxy is the service's own proxy, nil for direct connections.
+	proxy    *url.URL
+	hasProxy bool
+	rate     *config.Rate
+	retry    *config.Retry
+	// retries records the retried attempts of the verification.
+	retries *retryLog
+}
+
+// serviceTransport adds a service's options to the context of its requests,
+// so they still share the client's connection pool and rate limits.
+type serviceTransport struct {
+	base    http.RoundTripper
+	options serviceOptions
+}
+
+func (t serviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(context.WithValue(req.Context(), serviceKey{}, t.options)))
+}
+
+// clientFor returns client, applying the service's proxy, rate limit and
+// retry settings to its requests and recording retried attempts in retries.
+// client must come from NewClient.
+func clientFor(client *http.Client, service config.Service, retries *retryLog) (*http.Client, error) {
+	options := serviceOptions{retry: service.Retry, retries: retries}
+	if service.Proxy != "" {
+		proxy, err := config.ParseProxy(service.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		options.proxy, options.hasProxy = proxy, true
+	}
+	if service.RateLimit != "" {
+		rate, err := config.ParseRate(service.RateLimit)
+		if err != nil {
+			return nil, err
+		}
+		options.rate = &rate
+	}
+	routed := *client
+	routed.Transport = serviceTransport{base: client.Transport, options: options}
+	return &routed, nil
+}
+
+// politeTransport waits for each host's rate limit before sending a request,
+// gives each attempt timeout to complete and retries transient failures. The
+// client itself has no timeout, which would count the waits.
+type politeTransport struct {
+	base    *http.Transport
+	limiter *limiter
+	// override replaces the services' rate limits when set.
+	override *config.Rate
+	retry    config.Retry
+	timeout  time.Duration
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	options, _ := req.Context().Value(serviceKey{}).(serviceOptions)
+	rate := t.override
+	if rate == nil {
+		rate = options.rate
+	}
+	policy := t.retry
+	if options.retry != nil {
+		policy = policy.Merge(*options.retry)
+	}
+
+	for attempt := 1; ; attempt++ {
+		if rate != nil && !rate.Unlimited() {
+			if err := t.limiter.wait(req.Context(), req.URL.Hostname(), *rate); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := t.send(req)
+
+		reason := retryReason(resp, err)
+		if reason == "" || attempt >= policy.MaxAttempts || req.Context().Err() != nil {
+			return resp, err
+		}
+		delay, ok := retryDelay(policy, attempt, resp)
+		if !ok {
+			return resp, err
+		}
+		next, rewindErr := rewind(req)
+		if rewindErr != nil {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		options.retries.add(Retry{Host: req.URL.Host, Reason: reason, Wait: delay})
+		if err := sleep(req.Context(), delay); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+}
+
+// send makes a single attempt at req within the timeout.
+func (t *politeTransport) send(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's timeout once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func orDefault(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+)
+
+// latency stands in for the round trip to a real provider, so that
+// concurrent verifications overlap as they do over the network.
+const latency = 2 * time.Millisecond
+
+// newTestServer returns a TLS server that accepts any key, and counts the
+// connections opened to it.
+func newTestServer(tb testing.TB, http2 bool) (*httptest.Server, *int64) {
+	var conns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(latency)
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&conns, 1)
+		}
+	}
+	server.EnableHTTP2 = http2
+	server.StartTLS()
+	tb.Cleanup(server.Close)
+	return server, &conns
+}
+
+func testService(url string) config.Service {
+	return config.Service{
+		Name:         "Test",
+		VerifyURL:    url,
+		VerifyMethod: "GET",
+		Validation: config.Validation{
+			StatusCode:       200,
+			SuccessIndicator: config.SuccessIndicator{Type: "json_key_exists", Key: "ok"},
+		},
+	}
+}
+
+// trust makes client accept the test server's certificate.
+func trust(client *http.Client, server *httptest.Server) {
+	transport := client.Transport.(*politeTransport).base
+	transport.TLSClientConfig.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+}
+
+// verifyInBursts verifies n keys in bursts of ten concurrent requests,
+// waiting for each burst to finish, the way a key's matching services are
+// verified together.
+func verifyInBursts(tb testing.TB, client *http.Client, svc config.Service, n int) {
+	for i := 0; i < n; i += 10 {
+		var wg sync.WaitGroup
+		for j := i; j < n && j < i+10; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if result := VerifyKey(context.Background(), client, svc, Credential{Parts: []string{"key"}}, false); !result.Valid() {
+					tb.Errorf("expected valid, got %s: %s", result.Status, result.Reason)
+				}
+			}()
+		}
+		wg.Wait()
+	}
+}
+
+func TestSharedClientReusesConnections(t *testing.T) {
+	server, conns := newTestServer(t, false)
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust(client, server)
+
+	verifyInBursts(t, client, testService(server.URL), 200)
+	if *conns > defaultMaxConnsPerHost {
+		t.Errorf("opened %d connections for 200 verifications, want at most %d", *conns, defaultMaxConnsPerHost)
+	}
+}
+
+func TestDisableHTTP2(t *testing.T) {
+	server, _ := newTestServer(t, true)
+	for _, disable := range []bool{false, true} {
+		client, err := NewClient(config.HTTP{DisableHTTP2: disable}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trust(client, server)
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.ProtoMajor == 2; got == disable {
+			t.Errorf("DisableHTTP2 = %v, but the response came over %s", disable, resp.Proto)
+		}
+	}
+}
+
+// BenchmarkVerifyDefaultTransport verifies keys the way VerifyKey used to,
+// with a new client per key over the default transport, which keeps only two
+// idle connections per host, so most requests in a burst of ten need a new
+// connection and TLS handshake.
+// BenchmarkVerifySharedClient uses NewClient instead. Both talk HTTP/1.1,
+// where connection reuse matters most.
+func BenchmarkVerifyDefaultTransport(b *testing.B) {
+	server, conns := newTestServer(b, false)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs}
+	b.ResetTimer()
+	verifyInBursts(b, &http.Client{Transport: transport}, testService(server.URL), b.N)
+	b.ReportMetric(float64(*conns)/float64(b.N), "conns/op")
+}
+
+func BenchmarkVerifySharedClient(b *testing.B) {
+	server, conns := newTestServer(b, false)
+	client, err := NewClient(config.HTTP{}, 5)
+	if err != nil {
+		b.Fatal(err)
+	}
+	trust(client, server)
+	b.ResetTimer()
+	verifyInBursts(b, client, testService(server.URL), b.N)
+	b.ReportMetric(float64(*conns)/float64(b.N), "conns/op")
+}
+
+func TestVerifyKeyCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client, err := NewClient(config.HTTP{}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	result := VerifyKey(ctx, client, testService(server.URL), Credential{Parts: []string{"key"}}, false)
+	if result.Status != StatusError || len(result.Retries) != 0 {
+		t.Errorf("expected error without retries, got %s after %d retries: %s", result.Status, len(result.Retries), result.Reason)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("cancelled verification took %v", elapsed)
+	}
+}
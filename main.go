@@ -2,28 +2,88 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/harshinsecurity/mantramatch/internal/config"
+	"github.com/harshinsecurity/mantramatch/internal/scan"
 	"github.com/harshinsecurity/mantramatch/internal/service"
 	"github.com/schollz/progressbar/v3"
 )
 
 var (
-	configFile   string
-	verbose      bool
-	silent       bool
-	timeout      int
-	listFile     string
-	listServices bool
-	initConfig   bool
+	configFile      string
+	verbose         bool
+	silent          bool
+	timeout         int
+	listFile        string
+	listServices    bool
+	initConfig      bool
+	delimiter       string
+	jsonOutput      bool
+	scanPath        string
+	scanGit         bool
+	maxCandidates   int
+	generic         bool
+	decoders        string
+	decodeDepth     int
+	maxConnsPerHost int
+	disableHTTP2    bool
+	proxy           string
+	caBundle        string
+	rateLimit       string
+	maxAttempts     int
+	concurrency     int
+	deadline        time.Duration
+	params          = paramFlags{}
 )
 
+// httpClient sends every verification request, so connections to the same
+// provider are reused across keys and rate limits apply across them.
+var httpClient *http.Client
+
+// verifySlots limits how many verifications run at once across all keys.
+var verifySlots chan struct{}
+
+var (
+	// errInterrupted ends a run stopped with Ctrl-C.
+	errInterrupted = errors.New("interrupted")
+	// errDeadline ends a run that outlasted -deadline.
+	errDeadline = errors.New("deadline reached")
+)
+
+// paramFlags collects repeated -param name=value flags.
+type paramFlags map[string]string
+
+func (p paramFlags) String() string {
+	var pairs []string
+	for _, name := range sortedKeys(p) {
+		pairs = append(pairs, name+"="+p[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (p paramFlags) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	p[name] = val
+	return nil
+}
+
 func init() {
 	flag.Usage = usage
 	homeDir, _ := os.UserHomeDir()
@@ -35,22 +95,49 @@ func init() {
 	flag.StringVar(&listFile, "list", "", "Path to file containing list of API keys")
 	flag.BoolVar(&listServices, "ls", false, "List supported services")
 	flag.BoolVar(&initConfig, "init-config", false, "Initialize default configuration file")
-	flag.Parse()
+	flag.StringVar(&delimiter, "delimiter", ",", "Separator between the parts of multi-part credentials")
+	flag.BoolVar(&jsonOutput, "json", false, "Print results as JSON lines")
+	flag.StringVar(&scanPath, "scan", "", "Path to a file or directory to scan for embedded API keys")
+	flag.BoolVar(&scanGit, "git", false, "With -scan, scan every commit, branch and stash of the git repository at the path")
+	flag.StringVar(&decoders, "decode", "base64,url,hex", "With -scan, comma-separated decoders to try for encoded keys (empty for none)")
+	flag.IntVar(&decodeDepth, "decode-depth", 2, "With -scan, how many nested encodings to decode")
+	flag.IntVar(&maxCandidates, "max-candidates", 5, "Verify each key against at most this many matching services, most specific first (0 for all)")
+	flag.BoolVar(&generic, "generic", false, "Also verify keys against services matched only by a generic pattern, such as 32 hex characters")
+	flag.IntVar(&maxConnsPerHost, "max-conns-per-host", 0, "Maximum connections open to one host at a time (default from the config file, or 10)")
+	flag.BoolVar(&disableHTTP2, "disable-http2", false, "Use HTTP/1.1 only")
+	flag.StringVar(&proxy, "proxy", "", "HTTP, HTTPS or SOCKS5 proxy URL for verification requests, or \"direct\" for none (default from the config file or environment)")
+	flag.StringVar(&caBundle, "ca-bundle", "", "PEM file of extra CA certificates to trust, e.g. an intercepting proxy's")
+	flag.StringVar(&rateLimit, "rate-limit", "", "Limit requests to each host, e.g. 5/s or 100/m, overriding the services' rate_limit (\"none\" for no limit)")
+	flag.IntVar(&maxAttempts, "max-attempts", 0, "Attempts per request before a transient failure is final, for services without their own retry settings (default from the config file, or 3; 1 disables retries)")
+	flag.IntVar(&concurrency, "concurrency", 10, "Maximum number of keys and verifications processed at once")
+	flag.DurationVar(&deadline, "deadline", 0, "Stop the whole run after this long, e.g. 30m, reporting the keys verified so far (default no limit)")
+	flag.Var(params, "param", "Service parameter as name=value, e.g. subdomain=acme (repeatable)")
 }
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "MantraMatch: A tool to identify and verify API keys\n\n")
-	fmt.Fprintf(os.Stderr, "Usage: mantramatch [options] <api-key>\n\n")
+	fmt.Fprintf(os.Stderr, "Usage: mantramatch [options] <api-key> [<credential-part>...]\n\n")
 	fmt.Fprintf(os.Stderr, "Options:\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\nExamples:\n")
 	fmt.Fprintf(os.Stderr, "  mantramatch -verbose -timeout=15 your_api_key_here\n")
 	fmt.Fprintf(os.Stderr, "  mantramatch -silent -list=keys.txt\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -concurrency 4 -rate-limit 2/s -list=keys.txt\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -json -list=keys.txt > results.jsonl\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -deadline 10m -list=keys.txt\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -scan ./src\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -scan . -git\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -scan ./k8s -decode base64\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch your_account_sid your_auth_token\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -param subdomain=acme your_zendesk_token\n")
+	fmt.Fprintf(os.Stderr, "  mantramatch -proxy http://127.0.0.1:8080 -ca-bundle burp.pem your_api_key_here\n")
 	fmt.Fprintf(os.Stderr, "  mantramatch -ls\n")
 	fmt.Fprintf(os.Stderr, "  mantramatch -init-config\n")
 }
 
 func main() {
+	flag.Parse()
+
 	if initConfig {
 		err := config.CreateDefaultConfig(configFile)
 		if err != nil {
@@ -77,16 +164,115 @@ func main() {
 		return
 	}
 
+	if maxConnsPerHost > 0 {
+		cfg.HTTP.MaxConnsPerHost = maxConnsPerHost
+	}
+	if disableHTTP2 {
+		cfg.HTTP.DisableHTTP2 = true
+	}
+	if proxy != "" {
+		cfg.HTTP.Proxy = proxy
+	}
+	if caBundle != "" {
+		cfg.HTTP.CABundle = caBundle
+	}
+	if rateLimit != "" {
+		cfg.HTTP.RateLimit = rateLimit
+	}
+	if maxAttempts > 0 {
+		cfg.HTTP.Retry.MaxAttempts = maxAttempts
+	}
+	if concurrency < 1 {
+		fmt.Println("Error: -concurrency must be at least 1")
+		os.Exit(1)
+	}
+	verifySlots = make(chan struct{}, concurrency)
+	httpClient, err = service.NewClient(cfg.HTTP, timeout)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	matcher, err := service.NewMatcher(cfg.Services)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %v\n", err)
+		os.Exit(1)
+	}
+
+	if listFile == "" && scanPath == "" && len(flag.Args()) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	ctx, stop := withInterrupts(deadline)
 	if listFile != "" {
-		processKeyList(cfg)
-	} else if len(flag.Args()) == 1 {
-		processKey(cfg, flag.Args()[0])
+		processKeyList(ctx, stop, matcher)
+	} else if scanPath != "" {
+		processScan(ctx, stop, matcher)
 	} else {
-		flag.Usage()
+		processKey(ctx, matcher, service.JoinArgs(flag.Args(), delimiter))
+	}
+
+	switch context.Cause(stop) {
+	case errInterrupted:
+		os.Exit(130)
+	case errDeadline:
 		os.Exit(1)
 	}
 }
 
+// withInterrupts returns the context verifications run in, which ends after
+// deadline if it is positive, and a context that ends when no more keys
+// should be started. The first Ctrl-C ends only the latter, so verifications
+// in progress can finish and be reported; a second one cancels them too.
+func withInterrupts(deadline time.Duration) (ctx, stop context.Context) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if deadline > 0 {
+		time.AfterFunc(deadline, func() { cancel(errDeadline) })
+	}
+	stop, finish := context.WithCancelCause(ctx)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "\nInterrupted: finishing verifications in progress; press Ctrl-C again to cancel them")
+		finish(errInterrupted)
+		<-signals
+		// A third Ctrl-C kills the process outright.
+		signal.Stop(signals)
+		cancel(errInterrupted)
+	}()
+	return ctx, stop
+}
+
+// acquire takes a slot in semaphore for the next key, or returns false once
+// stop has ended.
+func acquire(stop context.Context, semaphore chan struct{}) bool {
+	select {
+	case semaphore <- struct{}{}:
+		if stop.Err() != nil {
+			<-semaphore
+			return false
+		}
+		return true
+	case <-stop.Done():
+		return false
+	}
+}
+
+// reportStopped says how far a run got if stop ended it early.
+func reportStopped(stop context.Context, verified, total int) {
+	if stop.Err() == nil {
+		return
+	}
+	what := "Interrupted"
+	if context.Cause(stop) == errDeadline {
+		what = "Deadline reached"
+	}
+	fmt.Fprintf(os.Stderr, "\n%s: verified %d of %d keys\n", what, verified, total)
+}
+
 func printSupportedServices(cfg *config.Config) {
 	fmt.Println("Supported services:")
 	for _, service := range cfg.Services {
@@ -94,22 +280,223 @@ func printSupportedServices(cfg *config.Config) {
 	}
 }
 
-func processKey(cfg *config.Config, apiKey string) {
-	matchedServices := service.MatchServices(cfg.Services, apiKey)
-	if len(matchedServices) == 0 {
-		if !silent {
+// processKey verifies and reports a single key. If ctx ended before the
+// verifications were complete, the ones that finished are reported with the
+// rest marked as skipped, and it returns false.
+func processKey(ctx context.Context, matcher *service.Matcher, apiKey string) bool {
+	cred := service.ParseCredential(apiKey, delimiter).WithParams(params)
+	candidates := matcher.Rank(cred)
+	if len(candidates) == 0 {
+		if jsonOutput {
+			printJSON(jsonResult{Key: apiKey, Status: string(service.StatusInvalid), Reason: "no matching services"})
+		} else if !silent {
 			fmt.Printf("%s : invalid\n", apiKey)
 			fmt.Println("No matching services found for the given API key.")
 			fmt.Println(strings.Repeat("-", 40))
 		}
-		return
+		return true
+	}
+
+	results := verifyCandidates(ctx, candidates, cred)
+	printKeyResults(results, apiKey, nil, candidates)
+	return ctx.Err() == nil
+}
+
+// verifyCandidates verifies cred against the candidates selectCandidates
+// picks and marks the rest as skipped.
+func verifyCandidates(ctx context.Context, candidates []service.Candidate, cred service.Credential) map[string]service.Result {
+	checked, skipped := selectCandidates(candidates)
+	results := verifyKeys(ctx, checked, cred)
+	for _, candidate := range candidates {
+		result := results[candidate.Service.Name]
+		if reason, ok := skipped[candidate.Service.Name]; ok {
+			result = service.Result{
+				Service: candidate.Service.Name,
+				Status:  service.StatusSkipped,
+				Reason:  reason,
+			}
+		}
+		result.Confidence = candidate.Confidence
+		results[candidate.Service.Name] = result
+	}
+	return results
+}
+
+// selectCandidates picks the candidates to verify, in rank order, and says
+// why each of the others is skipped. When the surrounding context pointed
+// at some of the candidates, only those are verified. Candidates matched
+// only by a generic pattern are verified with -generic. At most
+// -max-candidates are verified, except that candidates tied with the last
+// one are too, since nothing tells them apart.
+func selectCandidates(candidates []service.Candidate) (checked []config.Service, skipped map[string]string) {
+	skipped = make(map[string]string)
+	contextual := countContextMatches(candidates) > 0
+	last := -1.0
+	for _, candidate := range candidates {
+		name := candidate.Service.Name
+		switch {
+		case contextual && !candidate.ContextMatch:
+			skipped[name] = "the surrounding text points to other services"
+		case candidate.Generic && !generic:
+			skipped[name] = "matched only by a generic pattern; use -generic to check it"
+		case maxCandidates > 0 && len(checked) >= maxCandidates && candidate.Confidence != last:
+			skipped[name] = fmt.Sprintf("ranked below the top %d matches; use -max-candidates 0 to check all", maxCandidates)
+		default:
+			checked = append(checked, candidate.Service)
+			last = candidate.Confidence
+		}
+	}
+	return checked, skipped
+}
+
+// countContextMatches returns how many candidates, which Rank puts first,
+// matched the context the key was found in.
+func countContextMatches(candidates []service.Candidate) int {
+	n := 0
+	for _, candidate := range candidates {
+		if candidate.ContextMatch {
+			n++
+		}
+	}
+	return n
+}
+
+func printKeyResults(results map[string]service.Result, apiKey string, finding *scan.Finding, candidates []service.Candidate) {
+	var services []config.Service
+	for _, candidate := range candidates {
+		services = append(services, candidate.Service)
+	}
+	if jsonOutput {
+		printJSONResults(results, apiKey, finding, services)
+	} else {
+		printResults(results, apiKey, finding, services)
+	}
+}
+
+// processScan scans scanPath, or with -git its history, for embedded keys
+// and verifies each distinct key once, then reports every place it was found.
+func processScan(ctx, stop context.Context, matcher *service.Matcher) {
+	scanner := scan.New(matcher)
+	scanner.Decoders = nil
+	for _, name := range strings.Split(decoders, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		decoder, ok := scan.LookupDecoder(name)
+		if !ok {
+			fmt.Printf("Error: unknown decoder %q\n", name)
+			os.Exit(1)
+		}
+		scanner.Decoders = append(scanner.Decoders, decoder)
+	}
+	scanner.MaxDecodeDepth = decodeDepth
+	scanner.OnError = func(path string, err error) {
+		// A truncated archive is always reported: keys in the rest of it
+		// were missed.
+		if verbose || errors.Is(err, scan.ErrArchiveTooLarge) {
+			fmt.Fprintf(os.Stderr, "Error scanning %s: %v\n", path, err)
+		}
+	}
+
+	var findings []scan.Finding
+	collect := func(f scan.Finding) {
+		findings = append(findings, f)
+	}
+	if scanGit {
+		// The same key is usually seen in many commits; keep one finding
+		// per key and path, attributed to the oldest commit that added it.
+		index := make(map[[2]string]int)
+		collect = func(f scan.Finding) {
+			findings = keepOldest(findings, index, f)
+		}
+	}
+
+	var err error
+	if scanGit {
+		err = scanner.ScanGit(scanPath, collect)
+	} else {
+		err = scanner.ScanPath(scanPath, collect)
+	}
+	if err != nil {
+		fmt.Printf("Error scanning %s: %v\n", scanPath, err)
+		os.Exit(1)
+	}
+
+	// Each key is ranked using the context of every place it was found.
+	type verification struct {
+		cred       service.Credential
+		candidates []service.Candidate
+		results    map[string]service.Result
+	}
+	verified := make(map[string]*verification)
+	for _, f := range findings {
+		v := verified[f.Secret]
+		if v == nil {
+			v = &verification{cred: service.Credential{Parts: []string{f.Secret}}.WithParams(params)}
+			verified[f.Secret] = v
+		}
+		if f.KeyName != "" {
+			v.cred.KeyNames = append(v.cred.KeyNames, f.KeyName)
+		}
+		v.cred.Nearby = append(v.cred.Nearby, f.Nearby)
 	}
 
-	results := verifyKeys(matchedServices, apiKey)
-	printResults(results, apiKey, matchedServices)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+
+	var done int64
+
+	bar := progressbar.Default(int64(len(verified)))
+
+	for _, v := range verified {
+		if !acquire(stop, semaphore) {
+			break
+		}
+		wg.Add(1)
+		go func(v *verification) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			candidates := matcher.Rank(v.cred)
+			v.candidates, v.results = candidates, verifyCandidates(ctx, candidates, v.cred)
+			if ctx.Err() != nil {
+				return
+			}
+			atomic.AddInt64(&done, 1)
+			bar.Add(1)
+		}(v)
+	}
+
+	wg.Wait()
+
+	// Findings whose key the run was stopped before starting on are left
+	// out; those stopped partway show the verifications that finished.
+	for i := range findings {
+		v := verified[findings[i].Secret]
+		if v.results == nil {
+			continue
+		}
+		printKeyResults(v.results, findings[i].Secret, &findings[i], v.candidates)
+	}
+	reportStopped(stop, int(done), len(verified))
 }
 
-func processKeyList(cfg *config.Config) {
+// keepOldest adds f to findings unless its key was already found at the same
+// path, in which case only the finding from the older commit is kept. index
+// maps each key and path to the position of its finding.
+func keepOldest(findings []scan.Finding, index map[[2]string]int, f scan.Finding) []scan.Finding {
+	id := [2]string{f.Secret, f.Path}
+	if i, ok := index[id]; ok {
+		if f.Commit.Date.Before(findings[i].Commit.Date) {
+			findings[i] = f
+		}
+		return findings
+	}
+	index[id] = len(findings)
+	return append(findings, f)
+}
+
+func processKeyList(ctx, stop context.Context, matcher *service.Matcher) {
 	file, err := os.Open(listFile)
 	if err != nil {
 		fmt.Printf("Error opening file: %v\n", err)
@@ -129,26 +516,35 @@ func processKeyList(cfg *config.Config) {
 	}
 
 	var wg sync.WaitGroup
-	semaphore := make(chan struct{}, 10) // Limit concurrency to 10 goroutines
+	semaphore := make(chan struct{}, concurrency)
+
+	var done int64
 
 	bar := progressbar.Default(int64(len(keys)))
 
 	for _, apiKey := range keys {
+		if !acquire(stop, semaphore) {
+			break
+		}
 		wg.Add(1)
-		semaphore <- struct{}{}
 		go func(key string) {
 			defer wg.Done()
 			defer func() { <-semaphore }()
-			processKey(cfg, key)
-			bar.Add(1)
+			if processKey(ctx, matcher, key) {
+				atomic.AddInt64(&done, 1)
+				bar.Add(1)
+			}
 		}(apiKey)
 	}
 
 	wg.Wait()
+	reportStopped(stop, int(done), len(keys))
 }
 
-func verifyKeys(services []config.Service, apiKey string) map[string]bool {
-	results := make(map[string]bool)
+// verifyKeys verifies cred against each of services. Verifications that
+// ctx ended before they could finish are reported as skipped.
+func verifyKeys(ctx context.Context, services []config.Service, cred service.Credential) map[string]service.Result {
+	results := make(map[string]service.Result)
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 
@@ -156,9 +552,24 @@ func verifyKeys(services []config.Service, apiKey string) map[string]bool {
 		wg.Add(1)
 		go func(s config.Service) {
 			defer wg.Done()
-			valid := service.VerifyKey(s, apiKey, timeout, verbose)
+			var result service.Result
+			select {
+			case verifySlots <- struct{}{}:
+				result = service.VerifyKey(ctx, httpClient, s, cred, verbose)
+				<-verifySlots
+			case <-ctx.Done():
+			}
+			// An error after ctx ended is most likely the cancellation
+			// itself, not something the service did.
+			if ctx.Err() != nil && (result.Status == "" || result.Status == service.StatusError) {
+				result = service.Result{
+					Service: s.Name,
+					Status:  service.StatusSkipped,
+					Reason:  "the run was stopped before the verification finished",
+				}
+			}
 			mu.Lock()
-			results[s.Name] = valid
+			results[s.Name] = result
 			mu.Unlock()
 		}(svc)
 	}
@@ -167,16 +578,68 @@ func verifyKeys(services []config.Service, apiKey string) map[string]bool {
 	return results
 }
 
-func printResults(results map[string]bool, apiKey string, services []config.Service) {
+func printResults(results map[string]service.Result, apiKey string, finding *scan.Finding, services []config.Service) {
 	foundValid := false
+	inconclusive := false
 	for _, s := range services {
-		valid := results[s.Name]
-		status := "invalid"
-		if valid {
-			status = "valid"
+		result := results[s.Name]
+		switch result.Status {
+		case service.StatusValid:
 			foundValid = true
+		case service.StatusRateLimited, service.StatusError, service.StatusUnverifiable, service.StatusUnknown, service.StatusSkipped:
+			inconclusive = true
+		}
+		fmt.Printf("%s : %s\n", apiKey, result.Status)
+		if finding != nil {
+			fmt.Printf("Location: %s\n", finding.Location())
+			if c := finding.Commit; c != nil {
+				fmt.Printf("Commit: %s (%s <%s>, %s)\n", c.Hash, c.Author, c.Email, c.Date.Format(time.RFC3339))
+			}
+			if finding.Layer != "" {
+				fmt.Printf("Layer: %s\n", finding.Layer)
+			}
+			if len(finding.Decoded) > 0 {
+				fmt.Printf("Decoded: %s\n", strings.Join(finding.Decoded, " > "))
+			}
+		}
+
+		if !silent {
+			fmt.Printf("Service: %s\n", s.Name)
+			if result.Reason != "" {
+				fmt.Printf("Reason: %s\n", result.Reason)
+			}
+			if result.HTTPStatus != 0 {
+				fmt.Printf("HTTP status: %d\n", result.HTTPStatus)
+			}
+			fmt.Printf("Confidence: %.2f\n", result.Confidence)
+			if result.Status != service.StatusUnverifiable && result.Status != service.StatusSkipped {
+				fmt.Printf("Latency: %s\n", result.Latency.Round(time.Millisecond))
+			}
+			for _, retry := range result.Retries {
+				fmt.Printf("Retried: %s: %s (waited %s)\n", retry.Host, retry.Reason, retry.Wait.Round(time.Millisecond))
+			}
+			for _, param := range result.MissingParameters {
+				fmt.Printf("Parameter %s: %s (set with -param %s=..., %s, or a %s=... annotation)\n",
+					param.Name, param.Description, param.Name, param.EnvVar(), param.Name)
+			}
+		}
+
+		for _, name := range sortedKeys(result.Details) {
+			fmt.Printf("%s: %s\n", name, result.Details[name])
+		}
+		if result.Severity != "" {
+			fmt.Printf("Severity: %s\n", result.Severity)
+		}
+		for _, capability := range result.Capabilities {
+			switch {
+			case capability.Granted:
+				fmt.Printf("Capability %s: granted (%s)\n", capability.Name, capability.Severity)
+			case capability.Reason != "":
+				fmt.Printf("Capability %s: unknown (%s)\n", capability.Name, capability.Reason)
+			default:
+				fmt.Printf("Capability %s: denied\n", capability.Name)
+			}
 		}
-		fmt.Printf("%s : %s\n", apiKey, status)
 
 		if !silent && s.Note != "" {
 			fmt.Printf("Note: %s\n", s.Note)
@@ -189,7 +652,111 @@ func printResults(results map[string]bool, apiKey string, services []config.Serv
 
 	if !foundValid && !silent {
 		fmt.Println("No valid services found for this API key.")
-		fmt.Println("This could mean the key is invalid, expired, or not supported by MantraMatch.")
+		if inconclusive {
+			fmt.Println("Some services could not be checked (rate limited, unreachable, skipped, missing input or an unrecognised response); the key may still be valid for them.")
+		} else {
+			fmt.Println("This could mean the key is invalid, expired, or not supported by MantraMatch.")
+		}
 		fmt.Println(strings.Repeat("-", 40))
 	}
 }
+
+// jsonResult is one line of -json output.
+type jsonResult struct {
+	Key          string            `json:"key"`
+	File         string            `json:"file,omitempty"`
+	Line         int               `json:"line,omitempty"`
+	Column       int               `json:"column,omitempty"`
+	Layer        string            `json:"layer,omitempty"`
+	Decoded      []string          `json:"decoded,omitempty"`
+	Commit       string            `json:"commit,omitempty"`
+	Author       string            `json:"author,omitempty"`
+	Email        string            `json:"email,omitempty"`
+	Date         string            `json:"date,omitempty"`
+	Service      string            `json:"service,omitempty"`
+	Status       string            `json:"status"`
+	Reason       string            `json:"reason,omitempty"`
+	HTTPStatus   int               `json:"http_status,omitempty"`
+	LatencyMs    int64             `json:"latency_ms,omitempty"`
+	Confidence   float64           `json:"confidence,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
+	Severity     string            `json:"severity,omitempty"`
+	Capabilities []jsonCapability  `json:"capabilities,omitempty"`
+	Retries      []jsonRetry       `json:"retries,omitempty"`
+	Note         string            `json:"note,omitempty"`
+}
+
+type jsonRetry struct {
+	Host   string `json:"host"`
+	Reason string `json:"reason"`
+	WaitMs int64  `json:"wait_ms"`
+}
+
+type jsonCapability struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+	Granted  bool   `json:"granted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func printJSONResults(results map[string]service.Result, apiKey string, finding *scan.Finding, services []config.Service) {
+	for _, s := range services {
+		result := results[s.Name]
+		if silent && !result.Valid() {
+			continue
+		}
+		var capabilities []jsonCapability
+		for _, capability := range result.Capabilities {
+			capabilities = append(capabilities, jsonCapability{
+				Name:     capability.Name,
+				Severity: capability.Severity,
+				Granted:  capability.Granted,
+				Reason:   capability.Reason,
+			})
+		}
+		var retries []jsonRetry
+		for _, retry := range result.Retries {
+			retries = append(retries, jsonRetry{Host: retry.Host, Reason: retry.Reason, WaitMs: retry.Wait.Milliseconds()})
+		}
+		entry := jsonResult{
+			Key:          apiKey,
+			Service:      s.Name,
+			Status:       string(result.Status),
+			Reason:       result.Reason,
+			HTTPStatus:   result.HTTPStatus,
+			LatencyMs:    result.Latency.Milliseconds(),
+			Confidence:   result.Confidence,
+			Details:      result.Details,
+			Severity:     result.Severity,
+			Capabilities: capabilities,
+			Retries:      retries,
+			Note:         s.Note,
+		}
+		if finding != nil {
+			entry.File, entry.Line, entry.Column = finding.Path, finding.Line, finding.Column
+			entry.Layer, entry.Decoded = finding.Layer, finding.Decoded
+			if c := finding.Commit; c != nil {
+				entry.Commit, entry.Author, entry.Email, entry.Date = c.Hash, c.Author, c.Email, c.Date.Format(time.RFC3339)
+			}
+		}
+		printJSON(entry)
+	}
+}
+
+func printJSON(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error encoding result: %v\n", err)
+		return
+	}
+	fmt.Println(string(data))
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
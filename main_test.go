@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/harshinsecurity/mantramatch/internal/config"
+	"github.com/harshinsecurity/mantramatch/internal/scan"
+	"github.com/harshinsecurity/mantramatch/internal/service"
+)
+
+func candidate(name string, confidence float64, contextMatch, isGeneric bool) service.Candidate {
+	return service.Candidate{
+		Service:      config.Service{Name: name},
+		Confidence:   confidence,
+		ContextMatch: contextMatch,
+		Generic:      isGeneric,
+	}
+}
+
+func TestSelectCandidates(t *testing.T) {
+	specific := []service.Candidate{
+		candidate("A", 0.9, false, false),
+		candidate("B", 0.8, false, false),
+		candidate("C", 0.7, false, false),
+	}
+	tied := []service.Candidate{
+		candidate("A", 0.4, false, true),
+		candidate("B", 0.4, false, true),
+		candidate("C", 0.4, false, true),
+		candidate("D", 0.3, false, true),
+	}
+	tests := []struct {
+		name          string
+		candidates    []service.Candidate
+		maxCandidates int
+		generic       bool
+		want          []string
+	}{
+		{"all specific", specific, 5, false, []string{"A", "B", "C"}},
+		{"limit", specific, 2, false, []string{"A", "B"}},
+		{"generic only on request", tied, 5, false, nil},
+		{"generic requested", tied, 5, true, []string{"A", "B", "C", "D"}},
+		{"ties at the limit are kept", tied, 2, true, []string{"A", "B", "C"}},
+		{"specific before generic", append([]service.Candidate{candidate("S", 0.6, false, false)}, tied...), 5, false, []string{"S"}},
+		{"context", []service.Candidate{candidate("X", 0.5, true, false), candidate("A", 0.9, false, false)}, 5, false, []string{"X"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxCandidates, generic = tt.maxCandidates, tt.generic
+			defer func() { maxCandidates, generic = 5, false }()
+
+			checked, skipped := selectCandidates(tt.candidates)
+			var got []string
+			for _, s := range checked {
+				got = append(got, s.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checked %v, want %v", got, tt.want)
+			}
+			if len(checked)+len(skipped) != len(tt.candidates) {
+				t.Errorf("checked %v and skipped %v, want every candidate in one of them", got, skipped)
+			}
+		})
+	}
+}
+
+func TestKeepOldest(t *testing.T) {
+	found := func(secret, path, hash string, year int) scan.Finding {
+		return scan.Finding{
+			Secret: secret,
+			Path:   path,
+			Commit: &scan.Commit{Hash: hash, Date: time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)},
+		}
+	}
+	var findings []scan.Finding
+	index := make(map[[2]string]int)
+	for _, f := range []scan.Finding{
+		found("key1", "a.env", "c2023", 2023),
+		found("key2", "a.env", "c2023", 2023),
+		found("key1", "a.env", "c2021", 2021),
+		found("key1", "b.env", "c2022", 2022),
+		found("key1", "a.env", "c2022", 2022),
+		found("key2", "a.env", "other2023", 2023),
+	} {
+		findings = keepOldest(findings, index, f)
+	}
+
+	var got [][3]string
+	for _, f := range findings {
+		got = append(got, [3]string{f.Secret, f.Path, f.Commit.Hash})
+	}
+	want := [][3]string{
+		{"key1", "a.env", "c2021"},
+		{"key2", "a.env", "c2023"},
+		{"key1", "b.env", "c2022"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("kept %v, want %v", got, want)
+	}
+}
+
+func TestVerifyKeysStopped(t *testing.T) {
+	started := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fast" {
+			fmt.Fprint(w, `{"ok":true}`)
+			return
+		}
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	var err error
+	httpClient, err = service.NewClient(config.HTTP{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifySlots = make(chan struct{}, 1)
+	svc := func(name, path string) config.Service {
+		return config.Service{
+			Name:         name,
+			VerifyURL:    server.URL + path,
+			VerifyMethod: "GET",
+			Validation: config.Validation{
+				StatusCode:       200,
+				SuccessIndicator: config.SuccessIndicator{Type: "json_key_exists", Key: "ok"},
+			},
+		}
+	}
+	cred := service.Credential{Parts: []string{"key"}}
+
+	// Fast finishes first; one of the slow two is then cut off in flight
+	// and the other before it gets a slot.
+	ctx, cancel := context.WithCancel(context.Background())
+	results := verifyKeys(ctx, []config.Service{svc("Fast", "/fast")}, cred)
+	go func() {
+		<-started
+		cancel()
+	}()
+	for name, result := range verifyKeys(ctx, []config.Service{svc("Slow1", "/slow"), svc("Slow2", "/slow")}, cred) {
+		results[name] = result
+	}
+
+	want := map[string]service.Status{"Fast": service.StatusValid, "Slow1": service.StatusSkipped, "Slow2": service.StatusSkipped}
+	for name, status := range want {
+		if results[name].Status != status {
+			t.Errorf("%s: got %s: %s, want %s", name, results[name].Status, results[name].Reason, status)
+		}
+	}
+}